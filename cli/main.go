@@ -30,6 +30,8 @@ func main() {
 	var tlsLog = flag.String("tlslog", "", "Filename to write SSL secrets to (allows Wireshark to decrypt TLS connections)")
 	var connect_target = flag.String("connect-addr", "", "If set, tapdance will transparently connect to provided address, which must be either hostname:port or ip:port. " +
 		"Default(unset): connects client to forwardproxy, to which CONNECT request is yet to be written.")
+	var mux = flag.String("mux-addr", "", "If set, all connections are multiplexed over a single TapDance session to provided address, which must be a smux server.")
+	var keepalive = flag.Duration("keepalive", 0, "If set, TapDance connections send keepalives, when station is silent for this long, and are closed, if station doesn't answer within the same time.")
 	flag.Parse()
 
 	if *debug {
@@ -67,6 +69,12 @@ func main() {
 	}
 
 	tapdanceProxy := tdproxy.NewTapDanceProxy(*port)
+	if *mux != "" {
+		tapdanceProxy.EnableMultiplexing(*mux)
+	}
+	if *keepalive > 0 {
+		tapdanceProxy.EnableKeepalives(*keepalive, *keepalive)
+	}
 	err := tapdanceProxy.ListenAndServe()
 	if err != nil {
 		tdproxy.Logger.Errorf("Failed to ListenAndServe(): %v\n", err)
@@ -96,6 +104,7 @@ func connectDirect(connect_target string, localPort int) error {
 	wg.Add(2)
 	go func() {
 		io.Copy(tdConn, clientConn)
+		// TapDance can't half-close, and response to the upload, if any, is lost
 		tdConn.Close()
 		wg.Done()
 	}()
@@ -105,6 +114,8 @@ func connectDirect(connect_target string, localPort int) error {
 		wg.Done()
 	}()
 	wg.Wait()
+	tdConn.Close()
+	clientConn.Close()
 	return nil
 }
 
@@ -131,6 +131,7 @@ const (
 	S2C_Transition_S2C_SESSION_COVERT_INIT S2C_Transition = 11
 	S2C_Transition_S2C_CONFIRM_RECONNECT   S2C_Transition = 2
 	S2C_Transition_S2C_SESSION_CLOSE       S2C_Transition = 3
+	S2C_Transition_S2C_CONFIRM_YIELD       S2C_Transition = 4
 	// TODO should probably also allow EXPECT_RECONNECT here, for DittoTap
 	S2C_Transition_S2C_ERROR S2C_Transition = 255
 )
@@ -141,6 +142,7 @@ var S2C_Transition_name = map[int32]string{
 	11:  "S2C_SESSION_COVERT_INIT",
 	2:   "S2C_CONFIRM_RECONNECT",
 	3:   "S2C_SESSION_CLOSE",
+	4:   "S2C_CONFIRM_YIELD",
 	255: "S2C_ERROR",
 }
 
@@ -150,6 +152,7 @@ var S2C_Transition_value = map[string]int32{
 	"S2C_SESSION_COVERT_INIT": 11,
 	"S2C_CONFIRM_RECONNECT":   2,
 	"S2C_SESSION_CLOSE":       3,
+	"S2C_CONFIRM_YIELD":       4,
 	"S2C_ERROR":               255,
 }
 
@@ -315,7 +318,12 @@ type TLSDecoySpec struct {
 	// TODO: the default is based on the current heuristic of only
 	// using decoys that permit windows of 15KB or larger.  If this
 	// heuristic changes, then this default doesn't make sense.
-	Tcpwin               *uint32  `protobuf:"varint,5,opt,name=tcpwin" json:"tcpwin,omitempty"`
+	Tcpwin *uint32 `protobuf:"varint,5,opt,name=tcpwin" json:"tcpwin,omitempty"`
+	// SHA-256 hashes of DER-encoded SubjectPublicKeyInfo of certificates,
+	// expected in the decoy's chain.
+	//
+	// If present, the decoy's chain has to contain at least one of them.
+	SpkiPins             [][]byte `protobuf:"bytes,7,rep,name=spki_pins,json=spkiPins" json:"spki_pins,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -388,6 +396,13 @@ func (m *TLSDecoySpec) GetTcpwin() uint32 {
 	return 0
 }
 
+func (m *TLSDecoySpec) GetSpkiPins() [][]byte {
+	if m != nil {
+		return m.SpkiPins
+	}
+	return nil
+}
+
 type ClientConf struct {
 	DecoyList            *DecoyList `protobuf:"bytes,1,opt,name=decoy_list,json=decoyList" json:"decoy_list,omitempty"`
 	Generation           *uint32    `protobuf:"varint,2,opt,name=generation" json:"generation,omitempty"`
@@ -777,69 +792,71 @@ func init() {
 func init() { proto.RegisterFile("signalling.proto", fileDescriptor_39f66308029891ad) }
 
 var fileDescriptor_39f66308029891ad = []byte{
-	// 1024 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0xed, 0x6e, 0xe3, 0x44,
-	0x17, 0x5e, 0x37, 0xdd, 0x6e, 0x73, 0x92, 0x38, 0xee, 0xf4, 0xe3, 0xf5, 0xcb, 0x57, 0x43, 0x60,
-	0x21, 0x14, 0x54, 0xb1, 0x16, 0xdd, 0xe5, 0x6f, 0xd6, 0x35, 0x25, 0xda, 0xd4, 0x0e, 0x63, 0x77,
-	0x45, 0xe1, 0xc7, 0xc8, 0xb5, 0x27, 0xc5, 0x5a, 0xc7, 0x63, 0xd9, 0x93, 0xa2, 0xdc, 0x09, 0xdc,
-	0x03, 0xd7, 0xc0, 0x0d, 0x70, 0x0d, 0xfc, 0x46, 0xe2, 0x26, 0x40, 0x33, 0xe3, 0x24, 0x4e, 0x17,
-	0x2d, 0xe2, 0x9f, 0xcf, 0xf3, 0x9c, 0xaf, 0xe7, 0x9c, 0xe3, 0x01, 0xa3, 0x4c, 0x6e, 0xb3, 0x30,
-	0x4d, 0x93, 0xec, 0xf6, 0x34, 0x2f, 0x18, 0x67, 0x68, 0x97, 0x87, 0x79, 0x1c, 0x66, 0x11, 0xed,
-	0x0f, 0x61, 0x67, 0x32, 0xbf, 0x79, 0x41, 0x17, 0xc8, 0x80, 0xc6, 0x2b, 0xba, 0x30, 0xb5, 0x9e,
-	0x36, 0x68, 0x63, 0xf1, 0x89, 0x1e, 0xc3, 0x36, 0x5f, 0xe4, 0xd4, 0xdc, 0xea, 0x69, 0x03, 0xdd,
-	0xda, 0x3b, 0x5d, 0x06, 0x9d, 0xbe, 0xa0, 0x8b, 0x60, 0x91, 0x53, 0x2c, 0xe9, 0xfe, 0xaf, 0x1a,
-	0xb4, 0x83, 0xb1, 0x7f, 0x4e, 0x23, 0xb6, 0xf0, 0x73, 0x1a, 0xa1, 0xb7, 0x60, 0xf7, 0x07, 0x56,
-	0xf2, 0x2c, 0x9c, 0x51, 0x99, 0xae, 0x89, 0x57, 0xb6, 0xe0, 0x92, 0xfc, 0xee, 0x8b, 0x30, 0x8e,
-	0x0b, 0x99, 0xf7, 0x11, 0x5e, 0xd9, 0x15, 0xf7, 0x54, 0x72, 0x3b, 0xb2, 0x8d, 0x95, 0x8d, 0x06,
-	0xb0, 0x93, 0xcf, 0x6f, 0x44, 0x83, 0x8d, 0x9e, 0x36, 0x68, 0x59, 0xc6, 0xba, 0x1b, 0xd5, 0x3f,
-	0xae, 0x78, 0x64, 0xc2, 0x23, 0x9e, 0xcc, 0x28, 0x9b, 0x73, 0x73, 0xbb, 0xa7, 0x0d, 0x3a, 0x78,
-	0x69, 0xa2, 0x23, 0xd8, 0xe1, 0x51, 0xfe, 0x63, 0x92, 0x99, 0x0f, 0x25, 0x51, 0x59, 0xfd, 0x9f,
-	0x35, 0x00, 0x3b, 0x4d, 0x68, 0xc6, 0x6d, 0x96, 0x4d, 0x91, 0x05, 0x10, 0x0b, 0x2d, 0x24, 0x4d,
-	0x4a, 0x2e, 0x05, 0xb4, 0xac, 0xfd, 0x75, 0x39, 0xa9, 0x73, 0x9c, 0x94, 0x1c, 0x37, 0xe3, 0xe5,
-	0x27, 0x7a, 0x0f, 0xe0, 0x96, 0x66, 0xb4, 0x08, 0x79, 0xc2, 0x32, 0x29, 0xac, 0x83, 0x6b, 0x08,
-	0x7a, 0x06, 0x7a, 0x4c, 0xa7, 0xe1, 0x3c, 0xe5, 0xe4, 0x5f, 0x64, 0x74, 0x2a, 0xbf, 0x89, 0x74,
-	0xeb, 0x3f, 0x87, 0xe6, 0xaa, 0x20, 0x3a, 0x03, 0xe0, 0x69, 0x49, 0x64, 0xd9, 0xd2, 0xd4, 0x7a,
-	0x8d, 0x41, 0xcb, 0x3a, 0x5a, 0x67, 0xa8, 0x2f, 0x01, 0x37, 0x79, 0x5a, 0x4a, 0xab, 0xec, 0xff,
-	0xb6, 0x05, 0x5d, 0x9f, 0xcb, 0x46, 0x02, 0xa6, 0x84, 0xa2, 0x4f, 0xc0, 0x90, 0xa7, 0x10, 0xb1,
-	0x94, 0xdc, 0xd1, 0xa2, 0x14, 0x6d, 0x6b, 0xb2, 0xed, 0xee, 0x12, 0x7f, 0xa9, 0x60, 0x64, 0x83,
-	0x51, 0xf2, 0x90, 0x53, 0xc2, 0x8b, 0x30, 0x2b, 0x93, 0x95, 0x42, 0xdd, 0x32, 0xd7, 0xb5, 0x7d,
-	0xcb, 0x26, 0xc1, 0x8a, 0xc7, 0x5d, 0x19, 0xb1, 0x06, 0xd0, 0x19, 0xb4, 0x22, 0x96, 0x4d, 0x93,
-	0x5b, 0x92, 0x64, 0x53, 0x56, 0xa9, 0x3f, 0x58, 0xc7, 0xaf, 0xe7, 0x8f, 0x41, 0x39, 0x8e, 0xb2,
-	0x29, 0x43, 0xcf, 0x00, 0x68, 0x51, 0x90, 0x82, 0x86, 0x25, 0xcb, 0xe4, 0x3e, 0x37, 0xaa, 0x3a,
-	0x45, 0xc1, 0x0a, 0x2c, 0x49, 0xdf, 0xb2, 0x71, 0x93, 0x16, 0x95, 0x85, 0x8e, 0xa1, 0xc5, 0x67,
-	0x39, 0xb9, 0x09, 0xa3, 0x57, 0x6c, 0x3a, 0xad, 0x16, 0x0e, 0x7c, 0x96, 0x3f, 0x57, 0x08, 0x7a,
-	0x17, 0xa0, 0x54, 0x33, 0x21, 0x49, 0x2c, 0xcf, 0xad, 0x89, 0x9b, 0x15, 0x32, 0x8a, 0xc5, 0x15,
-	0xe5, 0x61, 0x1c, 0x27, 0xd9, 0xad, 0x19, 0xcb, 0x53, 0x5c, 0x9a, 0xfd, 0x3f, 0xb7, 0xa0, 0xab,
-	0xba, 0x0d, 0x58, 0x35, 0xd5, 0xff, 0x32, 0x4d, 0x0b, 0x0e, 0xd7, 0xd7, 0x45, 0x5e, 0x3b, 0x9a,
-	0xfd, 0xd5, 0x4d, 0x5d, 0xac, 0xaf, 0xe7, 0x9f, 0x36, 0xd0, 0xb8, 0x3f, 0x0b, 0xdb, 0xf2, 0xdf,
-	0xb8, 0x81, 0x63, 0x68, 0xcd, 0xf3, 0x94, 0x85, 0x31, 0x29, 0x17, 0x59, 0x24, 0x67, 0xb9, 0x8d,
-	0x41, 0x41, 0xfe, 0x22, 0x8b, 0xd0, 0x07, 0xd0, 0x99, 0x86, 0x49, 0x4a, 0xe3, 0xe5, 0x81, 0x41,
-	0xaf, 0x31, 0x68, 0xe2, 0xb6, 0x02, 0xd5, 0x2d, 0xa1, 0xcf, 0xe0, 0xa1, 0x48, 0x5c, 0x9a, 0x2d,
-	0xb9, 0xc1, 0xda, 0xf5, 0xf9, 0xb4, 0x14, 0x02, 0xc5, 0x48, 0x4a, 0xac, 0x9c, 0xd0, 0x63, 0xd0,
-	0x23, 0x76, 0x47, 0x0b, 0x4e, 0xc4, 0x4f, 0x4c, 0xcb, 0xd2, 0x3c, 0x90, 0x83, 0xee, 0x28, 0x74,
-	0xa8, 0xc0, 0x37, 0x0c, 0xfb, 0x77, 0x0d, 0xda, 0xf5, 0xc4, 0xe8, 0x73, 0x38, 0xd8, 0x68, 0x92,
-	0x84, 0x33, 0x36, 0xcf, 0xb8, 0xcc, 0xdb, 0xc1, 0xa8, 0xde, 0xeb, 0x50, 0x32, 0xe8, 0x09, 0x1c,
-	0x72, 0xc6, 0xc3, 0x94, 0x88, 0x67, 0x80, 0x70, 0x46, 0x22, 0x96, 0x65, 0x34, 0xe2, 0xe6, 0xb1,
-	0x0a, 0x91, 0x64, 0x90, 0xcc, 0x68, 0xc0, 0x6c, 0xc5, 0xa0, 0x0f, 0x41, 0x2f, 0x38, 0x17, 0xbe,
-	0xd5, 0x41, 0x98, 0xef, 0x4b, 0xdf, 0x76, 0xc1, 0x6b, 0x4b, 0xef, 0x41, 0x5b, 0xfc, 0x8d, 0x9c,
-	0xa9, 0x56, 0xcc, 0x8f, 0xaa, 0x1b, 0x4b, 0xcb, 0x80, 0xc9, 0x0e, 0xa4, 0x47, 0x94, 0xaf, 0x3d,
-	0x3e, 0xae, 0x3c, 0xa2, 0xbc, 0xf2, 0x38, 0xf9, 0x14, 0x1e, 0x55, 0x8f, 0x29, 0xea, 0x42, 0x6b,
-	0xe8, 0xf8, 0xe4, 0xc2, 0xbe, 0x24, 0x4f, 0xac, 0x2f, 0x8d, 0xef, 0xea, 0x80, 0x75, 0xf6, 0xd4,
-	0xf8, 0xfe, 0xe4, 0x0f, 0x0d, 0xf4, 0xcd, 0x2d, 0xa3, 0x3d, 0xe8, 0x08, 0xc4, 0xf5, 0x88, 0xfd,
-	0xf5, 0xd0, 0xbd, 0x70, 0x8c, 0x07, 0xe8, 0x00, 0x0c, 0x01, 0xf9, 0x8e, 0xef, 0x8f, 0x3c, 0x97,
-	0x8c, 0xdc, 0x51, 0x60, 0x68, 0xe8, 0x6d, 0xf8, 0x5f, 0x1d, 0xb5, 0xbd, 0x97, 0x0e, 0x0e, 0x14,
-	0xd9, 0x42, 0x26, 0x1c, 0x08, 0xd2, 0xf9, 0x76, 0xe2, 0xd8, 0x01, 0xc1, 0x8e, 0xed, 0xb9, 0xae,
-	0x63, 0x07, 0xc6, 0x16, 0x3a, 0x84, 0xbd, 0x8d, 0xb0, 0xb1, 0xe7, 0x3b, 0x46, 0x63, 0x59, 0xe3,
-	0x7a, 0xe4, 0x8c, 0xcf, 0xc9, 0xd5, 0x64, 0xec, 0x0d, 0xcf, 0x8d, 0x6d, 0x74, 0x04, 0x48, 0xa0,
-	0x43, 0xfb, 0x9b, 0xab, 0x11, 0x76, 0x96, 0xf8, 0x43, 0xd4, 0x83, 0x77, 0x6a, 0xe9, 0x15, 0xec,
-	0xb9, 0xe3, 0xeb, 0xaa, 0x92, 0xb1, 0x83, 0x74, 0x68, 0x4a, 0x0f, 0x8c, 0x3d, 0x6c, 0xfc, 0xa5,
-	0x9d, 0xfc, 0xa4, 0x81, 0xbe, 0xf9, 0xa2, 0x08, 0xa5, 0x02, 0xb9, 0xa7, 0x54, 0x40, 0xaf, 0x2b,
-	0xad, 0xa3, 0x9b, 0x4a, 0xff, 0x0f, 0x87, 0x82, 0xb4, 0x3d, 0xf7, 0xab, 0x11, 0xbe, 0xbc, 0x2f,
-	0x75, 0x23, 0xae, 0x92, 0xaa, 0x43, 0x53, 0xc0, 0xab, 0xd6, 0x7e, 0xd1, 0x40, 0xdf, 0x7c, 0x76,
-	0x50, 0x1b, 0x76, 0x5d, 0xaf, 0xf2, 0x78, 0x20, 0x57, 0xa2, 0x6a, 0xfa, 0x01, 0x76, 0x86, 0x97,
-	0x86, 0x86, 0xf6, 0xa1, 0x6b, 0x8f, 0x47, 0x8e, 0x2b, 0x66, 0x3b, 0xf1, 0x70, 0xe0, 0x9c, 0x1b,
-	0x5b, 0x35, 0x70, 0x82, 0xbd, 0xc0, 0xb3, 0xbd, 0xb1, 0x1a, 0xac, 0x1f, 0x0c, 0x03, 0x25, 0x27,
-	0x70, 0xb0, 0x3b, 0x1c, 0x1b, 0xdb, 0x08, 0x81, 0x7e, 0xee, 0xd8, 0xde, 0x35, 0x11, 0x79, 0xab,
-	0xa1, 0x8a, 0x32, 0x2a, 0xbc, 0x2a, 0x13, 0x0b, 0xb7, 0x0a, 0x0a, 0x46, 0x97, 0x8e, 0x77, 0x15,
-	0x18, 0xf4, 0xef, 0x00, 0x00, 0x00, 0xff, 0xff, 0xc5, 0xf2, 0xc6, 0x3e, 0xfc, 0x07, 0x00, 0x00,
+	// 1044 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0xdd, 0x6e, 0xe3, 0x44,
+	0x14, 0x5e, 0x37, 0xd9, 0x76, 0x73, 0xf2, 0xe7, 0x4e, 0x7f, 0x30, 0x2c, 0xd0, 0x10, 0x58, 0x08,
+	0x05, 0x55, 0xac, 0x45, 0x77, 0xb9, 0xcd, 0xba, 0xa6, 0x44, 0x9b, 0xc6, 0x61, 0xec, 0xae, 0x28,
+	0x5c, 0x8c, 0x5c, 0x7b, 0x52, 0xac, 0x3a, 0x1e, 0xcb, 0x33, 0x29, 0xca, 0xa3, 0xf0, 0x0e, 0x48,
+	0x3c, 0x08, 0x8f, 0x80, 0xb8, 0x46, 0xe2, 0x25, 0x40, 0x33, 0xe3, 0xfc, 0x75, 0xd1, 0x22, 0xee,
+	0x72, 0xbe, 0xef, 0xfc, 0x7c, 0xdf, 0x99, 0x13, 0x83, 0xc9, 0x93, 0x9b, 0x2c, 0x4c, 0xd3, 0x24,
+	0xbb, 0x39, 0xc9, 0x0b, 0x26, 0x18, 0x7a, 0x24, 0xc2, 0x3c, 0x0e, 0xb3, 0x88, 0x76, 0xfb, 0xb0,
+	0x3d, 0x9e, 0x5d, 0xbf, 0xa4, 0x73, 0x64, 0x42, 0xe5, 0x96, 0xce, 0x2d, 0xa3, 0x63, 0xf4, 0x1a,
+	0x58, 0xfe, 0x44, 0x4f, 0xa0, 0x2a, 0xe6, 0x39, 0xb5, 0xb6, 0x3a, 0x46, 0xaf, 0x65, 0xef, 0x9e,
+	0x2c, 0x8a, 0x4e, 0x5e, 0xd2, 0x79, 0x30, 0xcf, 0x29, 0x56, 0x74, 0xf7, 0x77, 0x03, 0x1a, 0xc1,
+	0xd0, 0x3f, 0xa3, 0x11, 0x9b, 0xfb, 0x39, 0x8d, 0xd0, 0x3b, 0xf0, 0xe8, 0x47, 0xc6, 0x45, 0x16,
+	0x4e, 0xa9, 0x6a, 0x57, 0xc3, 0xcb, 0x58, 0x72, 0x49, 0x7e, 0xf7, 0x65, 0x18, 0xc7, 0x85, 0xea,
+	0xbb, 0x83, 0x97, 0x71, 0xc9, 0x3d, 0x53, 0xdc, 0xb6, 0x92, 0xb1, 0x8c, 0x51, 0x0f, 0xb6, 0xf3,
+	0xd9, 0xb5, 0x14, 0x58, 0xe9, 0x18, 0xbd, 0xba, 0x6d, 0xae, 0xd4, 0x68, 0xfd, 0xb8, 0xe4, 0x91,
+	0x05, 0x3b, 0x22, 0x99, 0x52, 0x36, 0x13, 0x56, 0xb5, 0x63, 0xf4, 0x9a, 0x78, 0x11, 0xa2, 0x43,
+	0xd8, 0x16, 0x51, 0xfe, 0x53, 0x92, 0x59, 0x0f, 0x15, 0x51, 0x46, 0xe8, 0x31, 0xd4, 0x78, 0x7e,
+	0x9b, 0x90, 0x3c, 0xc9, 0xb8, 0xb5, 0xd3, 0xa9, 0xc8, 0xc1, 0x12, 0x18, 0x27, 0x19, 0xef, 0xfe,
+	0x6c, 0x00, 0x38, 0x69, 0x42, 0x33, 0xe1, 0xb0, 0x6c, 0x82, 0x6c, 0x80, 0x58, 0x1a, 0x25, 0x69,
+	0xc2, 0x85, 0x72, 0x57, 0xb7, 0xf7, 0x56, 0x5a, 0xd4, 0x12, 0x86, 0x09, 0x17, 0xb8, 0x16, 0x2f,
+	0x7e, 0xa2, 0xf7, 0x01, 0x6e, 0x68, 0x46, 0x8b, 0x50, 0x24, 0x2c, 0x53, 0xae, 0x9b, 0x78, 0x0d,
+	0x41, 0xcf, 0xa1, 0x15, 0xd3, 0x49, 0x38, 0x4b, 0x05, 0xf9, 0x0f, 0x8f, 0xcd, 0x32, 0x6f, 0xac,
+	0xd2, 0xba, 0x2f, 0xa0, 0xb6, 0x1c, 0x88, 0x4e, 0x01, 0x44, 0xca, 0x89, 0x1a, 0xcb, 0x2d, 0xa3,
+	0x53, 0xe9, 0xd5, 0xed, 0xc3, 0x55, 0x87, 0xf5, 0x17, 0xc2, 0x35, 0x91, 0x72, 0x15, 0xf1, 0xee,
+	0x6f, 0x5b, 0xd0, 0xf6, 0x85, 0x12, 0x12, 0x30, 0x6d, 0x14, 0x7d, 0x0a, 0xa6, 0xba, 0x93, 0x88,
+	0xa5, 0xe4, 0x8e, 0x16, 0x5c, 0xca, 0x36, 0x94, 0xec, 0xf6, 0x02, 0x7f, 0xa5, 0x61, 0xe4, 0x80,
+	0xc9, 0x45, 0x28, 0x28, 0x11, 0x45, 0x98, 0xf1, 0x64, 0xe9, 0xb0, 0x65, 0x5b, 0xab, 0xd9, 0xbe,
+	0xed, 0x90, 0x60, 0xc9, 0xe3, 0xb6, 0xaa, 0x58, 0x01, 0xe8, 0x14, 0xea, 0x11, 0xcb, 0x26, 0xc9,
+	0x0d, 0x49, 0xb2, 0x09, 0x2b, 0xdd, 0xef, 0xaf, 0xea, 0x57, 0xfb, 0xc7, 0xa0, 0x13, 0x07, 0xd9,
+	0x84, 0xa1, 0xe7, 0x00, 0xb4, 0x28, 0x48, 0x41, 0x43, 0xce, 0x32, 0xab, 0x7a, 0x7f, 0xaa, 0x5b,
+	0x14, 0xac, 0xc0, 0x8a, 0xf4, 0x6d, 0x07, 0xd7, 0x68, 0x51, 0x46, 0xe8, 0x08, 0xea, 0x62, 0x9a,
+	0x93, 0xeb, 0x30, 0xba, 0x65, 0x93, 0x49, 0x79, 0x0d, 0x20, 0xa6, 0xf9, 0x0b, 0x8d, 0xa0, 0xf7,
+	0x00, 0xb8, 0xde, 0x09, 0x49, 0x62, 0x75, 0x8b, 0x35, 0x5c, 0x2b, 0x91, 0x41, 0x2c, 0x4f, 0x2c,
+	0x0f, 0xe3, 0x38, 0xc9, 0x6e, 0xac, 0x58, 0xdd, 0xe9, 0x22, 0xec, 0xfe, 0xb5, 0x05, 0x6d, 0xad,
+	0x36, 0x60, 0xe5, 0x56, 0xff, 0xcf, 0x36, 0x6d, 0x38, 0x58, 0x5d, 0x17, 0x79, 0xed, 0x68, 0xf6,
+	0x96, 0x37, 0x75, 0xbe, 0xa4, 0xfe, 0xf5, 0x05, 0x2a, 0xf7, 0x77, 0xe1, 0xd8, 0xfe, 0x1b, 0x5f,
+	0xe0, 0x08, 0xea, 0xb3, 0x3c, 0x65, 0x61, 0x4c, 0xf8, 0x3c, 0x8b, 0xd4, 0x2e, 0xab, 0x18, 0x34,
+	0xe4, 0xcf, 0xb3, 0x08, 0x7d, 0x08, 0xcd, 0x49, 0x98, 0xa4, 0x34, 0x5e, 0x1c, 0x18, 0x74, 0x2a,
+	0xbd, 0x1a, 0x6e, 0x68, 0x50, 0xdf, 0x12, 0xfa, 0x1c, 0x1e, 0xca, 0xc6, 0xdc, 0xaa, 0x77, 0x8c,
+	0xcd, 0xeb, 0xf3, 0x29, 0x97, 0x06, 0xe5, 0x4a, 0x38, 0xd6, 0x49, 0xe8, 0x09, 0xb4, 0x22, 0x76,
+	0x47, 0x0b, 0x41, 0xe4, 0x3f, 0x9c, 0x72, 0x6e, 0xed, 0xab, 0x45, 0x37, 0x35, 0xda, 0xd7, 0xe0,
+	0x1b, 0x96, 0xfd, 0x87, 0x01, 0x8d, 0xf5, 0xc6, 0xe8, 0x0b, 0xd8, 0xdf, 0x10, 0x49, 0xc2, 0x29,
+	0x9b, 0x65, 0x42, 0xf5, 0x6d, 0x62, 0xb4, 0xae, 0xb5, 0xaf, 0x18, 0xf4, 0x14, 0x0e, 0x04, 0x13,
+	0x61, 0x4a, 0xe4, 0x37, 0x82, 0x08, 0x46, 0x22, 0x96, 0x65, 0x34, 0x12, 0xd6, 0x91, 0x2e, 0x51,
+	0x64, 0x90, 0x4c, 0x69, 0xc0, 0x1c, 0xcd, 0xa0, 0x8f, 0xa0, 0x55, 0x08, 0x21, 0x73, 0xcb, 0x83,
+	0xb0, 0x3e, 0x50, 0xb9, 0x8d, 0x42, 0xac, 0x3d, 0x7a, 0x07, 0x1a, 0xf2, 0xdf, 0x28, 0x98, 0x96,
+	0x62, 0x7d, 0x5c, 0xde, 0x58, 0xca, 0x03, 0xa6, 0x14, 0xa8, 0x8c, 0x28, 0x5f, 0x65, 0x7c, 0x52,
+	0x66, 0x44, 0x79, 0x99, 0x71, 0xfc, 0x19, 0xec, 0x94, 0x5f, 0x5a, 0xd4, 0x86, 0x7a, 0xdf, 0xf5,
+	0xc9, 0xb9, 0x73, 0x41, 0x9e, 0xda, 0x5f, 0x99, 0xdf, 0xaf, 0x03, 0xf6, 0xe9, 0x33, 0xf3, 0x87,
+	0xe3, 0x3f, 0x0d, 0x68, 0x6d, 0xbe, 0x32, 0xda, 0x85, 0xa6, 0x44, 0x46, 0x1e, 0x71, 0xbe, 0xe9,
+	0x8f, 0xce, 0x5d, 0xf3, 0x01, 0xda, 0x07, 0x53, 0x42, 0xbe, 0xeb, 0xfb, 0x03, 0x6f, 0x44, 0x06,
+	0xa3, 0x41, 0x60, 0x1a, 0xe8, 0x31, 0xbc, 0xb5, 0x8e, 0x3a, 0xde, 0x2b, 0x17, 0x07, 0x9a, 0xac,
+	0x23, 0x0b, 0xf6, 0x25, 0xe9, 0x7e, 0x37, 0x76, 0x9d, 0x80, 0x60, 0xd7, 0xf1, 0x46, 0x23, 0xd7,
+	0x09, 0xcc, 0x2d, 0x74, 0x00, 0xbb, 0x1b, 0x65, 0x43, 0xcf, 0x77, 0xcd, 0xca, 0x62, 0xc6, 0xd5,
+	0xc0, 0x1d, 0x9e, 0x91, 0xcb, 0xf1, 0xd0, 0xeb, 0x9f, 0x99, 0x55, 0x74, 0x08, 0x48, 0xa2, 0x7d,
+	0xe7, 0xdb, 0xcb, 0x01, 0x76, 0x17, 0xf8, 0x43, 0xd4, 0x81, 0x77, 0xd7, 0xda, 0x6b, 0xd8, 0x1b,
+	0x0d, 0xaf, 0xca, 0x49, 0xe6, 0x36, 0x6a, 0x41, 0x4d, 0x65, 0x60, 0xec, 0x61, 0xf3, 0x6f, 0xe3,
+	0xf8, 0x57, 0x03, 0x5a, 0x9b, 0x5f, 0x14, 0xe9, 0x54, 0x22, 0xf7, 0x9c, 0x4a, 0xe8, 0x75, 0xa7,
+	0xeb, 0xe8, 0xa6, 0xd3, 0xb7, 0xe1, 0x40, 0x92, 0x8e, 0x37, 0xfa, 0x7a, 0x80, 0x2f, 0xee, 0x5b,
+	0xdd, 0xa8, 0x2b, 0xad, 0x96, 0xf0, 0xa2, 0x42, 0x59, 0x36, 0xab, 0x52, 0xb1, 0x84, 0x97, 0x8a,
+	0x7f, 0x31, 0xa0, 0xb5, 0xf9, 0x35, 0x42, 0x0d, 0x78, 0x34, 0xf2, 0xca, 0x8c, 0x07, 0xea, 0xa5,
+	0xb4, 0x14, 0x3f, 0xc0, 0x6e, 0xff, 0xc2, 0x34, 0xd0, 0x1e, 0xb4, 0x9d, 0xe1, 0xc0, 0x1d, 0xc9,
+	0x95, 0x8f, 0x3d, 0x1c, 0xb8, 0x67, 0xe6, 0xd6, 0x1a, 0x38, 0xc6, 0x5e, 0xe0, 0x39, 0xde, 0x50,
+	0xef, 0xdb, 0x0f, 0xfa, 0x81, 0x76, 0x19, 0xb8, 0x78, 0xd4, 0x1f, 0x9a, 0x55, 0x84, 0xa0, 0x75,
+	0xe6, 0x3a, 0xde, 0x15, 0x91, 0x7d, 0xcb, 0x5d, 0xcb, 0x31, 0xba, 0xbc, 0x1c, 0x13, 0xcb, 0xb4,
+	0x12, 0x0a, 0x06, 0x17, 0xae, 0x77, 0x19, 0x98, 0xf4, 0x9f, 0x01, 0x00, 0xc1, 0x77, 0x5f, 0x13,
+	0x30, 0x08, 0x00, 0x00,
 }
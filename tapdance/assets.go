@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	pb "github.com/sergeyfrolov/gotapdance/protobuf"
@@ -24,9 +25,14 @@ type assets struct {
 
 	roots *x509.CertPool
 
+	health *decoyHealth
+
+	backoffUntil time.Time // station asked not to connect until then, shared by all users of assets
+
 	filenameStationPubkey string
 	filenameRoots         string
 	filenameClientConf    string
+	filenameDecoyHealth   string
 }
 
 // could reset this internally to refresh assets and avoid woes of singleton testing
@@ -85,10 +91,29 @@ func initAssets(path string) {
 		filenameRoots:         "roots",
 		filenameClientConf:    "ClientConf",
 		filenameStationPubkey: "station_pubkey",
+		filenameDecoyHealth:   "decoy_health.json",
+		health:                makeDecoyHealth(),
 	}
 	assetsInstance.readConfigs()
 }
 
+// makeAssetsFromConf returns assets, that are not backed by any directory: they are
+// kept in memory only and never stored to disk. Used by Dialers with their own ClientConf.
+func makeAssetsFromConf(conf *pb.ClientConf, roots *x509.CertPool) *assets {
+	a := &assets{
+		roots:                 roots,
+		filenameRoots:         "roots",
+		filenameClientConf:    "ClientConf",
+		filenameStationPubkey: "station_pubkey",
+		filenameDecoyHealth:   "decoy_health.json",
+		health:                makeDecoyHealth(),
+	}
+	if conf != nil {
+		a.config = *proto.Clone(conf).(*pb.ClientConf)
+	}
+	return a
+}
+
 func (a *assets) GetAssetsDir() string {
 	a.RLock()
 	defer a.RUnlock()
@@ -163,6 +188,19 @@ func (a *assets) readConfigs() {
 	} else {
 		Logger().Infoln("Pubkey successfully read from " + pubkeyFilename)
 	}
+
+	// changes, that are still pending, belong to the old file
+	if err = a.health.flushPending(); err != nil {
+		Logger().Warningln("Assets: failed to save decoy health: " + err.Error())
+	}
+	decoyHealthFilename := path.Join(a.path, a.filenameDecoyHealth)
+	err = a.health.load(decoyHealthFilename)
+	if err != nil {
+		Logger().Warningln("Assets: failed to read decoy health file: " + err.Error())
+	} else {
+		Logger().Debugln("Decoy health successfully read from " + decoyHealthFilename)
+	}
+	a.health.retain(a.config.GetDecoyList().GetTlsDecoys())
 }
 
 // Picks random decoy, returns Server Name Indication and addr in format ipv4:port
@@ -185,6 +223,16 @@ func (a *assets) GetDecoyAddress() (sni string, addr string) {
 
 // Gets random DecoySpec.
 func (a *assets) GetDecoy() pb.TLSDecoySpec {
+	return a.GetDecoyWithSelector(nil)
+}
+
+// Gets DecoySpec, picked by provided selector. If selector is nil, picks random decoy.
+// Decoys that are temporarily blacklisted for repeated failures are not offered to selector.
+func (a *assets) GetDecoyWithSelector(selector DecoySelector) pb.TLSDecoySpec {
+	if selector == nil {
+		selector = uniformDecoySelector{}
+	}
+
 	a.RLock()
 	defer a.RUnlock()
 
@@ -193,7 +241,8 @@ func (a *assets) GetDecoy() pb.TLSDecoySpec {
 	if len(decoys) == 0 {
 		return chosenDecoy
 	}
-	decoyIndex := getRandInt(0, len(decoys)-1)
+	decoys = a.health.filter(decoys)
+	decoyIndex := selector.SelectDecoy(decoys)
 	chosenDecoy = *decoys[decoyIndex]
 
 	// TODO: stop enforcing values >= defaults.
@@ -209,6 +258,38 @@ func (a *assets) GetDecoy() pb.TLSDecoySpec {
 	return chosenDecoy
 }
 
+// Records outcome of connection attempt via decoy to decoy health store
+func (a *assets) recordDecoyEvent(decoy *pb.TLSDecoySpec, event decoyEvent) {
+	a.health.record(decoy, event)
+}
+
+// FlushDecoyHealth saves outcomes of recent connection attempts right away. They are saved
+// a few seconds later otherwise, so call it before exit, not to lose them.
+func (a *assets) FlushDecoyHealth() error {
+	return a.health.flushPending()
+}
+
+// Postpones new connections and reconnects to the station, as requested by the station.
+// Backoff is kept in assets, so it is global for all Dialers and sessions, that share them,
+// which, unless assets are set explicitly, is the whole process: station asks clients to
+// back off as a whole, and doesn't tell which of our sessions it minds.
+func (a *assets) setBackoff(backoff time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+
+	if until := time.Now().Add(backoff); until.After(a.backoffUntil) {
+		a.backoffUntil = until
+	}
+}
+
+// Returns how long to wait before connecting to the station. Non-positive, if no need to wait.
+func (a *assets) backoffRemaining() time.Duration {
+	a.RLock()
+	defer a.RUnlock()
+
+	return time.Until(a.backoffUntil)
+}
+
 func (a *assets) GetRoots() *x509.CertPool {
 	a.RLock()
 	defer a.RUnlock()
@@ -260,6 +341,7 @@ func (a *assets) SetClientConf(conf *pb.ClientConf) (err error) {
 	defer a.Unlock()
 
 	a.config = *conf
+	a.health.retain(a.config.GetDecoyList().GetTlsDecoys())
 	err = a.saveClientConf()
 	return
 }
@@ -278,6 +360,7 @@ func (a *assets) SetDecoys(decoys []*pb.TLSDecoySpec) (err error) {
 		a.config.DecoyList = &pb.DecoyList{}
 	}
 	a.config.DecoyList.TlsDecoys = decoys
+	a.health.retain(decoys)
 	err = a.saveClientConf()
 	return
 }
@@ -298,6 +381,10 @@ func (a *assets) IsDecoyInList(decoy pb.TLSDecoySpec) bool {
 }
 
 func (a *assets) saveClientConf() error {
+	if a.path == "" {
+		// assets are not backed by directory
+		return nil
+	}
 	buf, err := proto.Marshal(&a.config)
 	if err != nil {
 		return err
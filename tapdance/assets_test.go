@@ -186,3 +186,50 @@ func TestAssets_Pubkey(t *testing.T) {
 	os.Remove(dir2)
 	AssetsSetDir(oldpath)
 }
+
+func TestAssets_DialerWithConf(t *testing.T) {
+	keyType := pb.KeyType_AES_GCM_128
+	pubkey := []byte{7, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26,
+		27, 28, 29, 30, 31}
+	generation := uint32(42)
+	conf := pb.ClientConf{
+		DecoyList: &pb.DecoyList{TlsDecoys: []*pb.TLSDecoySpec{
+			pb.InitTLSDecoySpec("4.8.15.16", "ericw.us"),
+		}},
+		DefaultPubkey: &pb.PubKey{Key: pubkey, Type: &keyType},
+		Generation:    &generation,
+	}
+	globalGeneration := Assets().GetGeneration()
+
+	d := NewDialerWithConf(&conf, nil)
+	if d.getAssets() == Assets() {
+		t.Fatal("Dialer with own ClientConf uses global Assets()")
+	}
+	// Dialer must keep its own copy of ClientConf
+	conf.DecoyList.TlsDecoys[0] = pb.InitTLSDecoySpec("19.21.23.42", "blahblahbl.ah")
+	if decoy := d.getAssets().GetDecoy(); decoy.GetHostname() != "ericw.us" {
+		t.Fatalf("Expected decoy ericw.us, got %s", decoy.GetHostname())
+	}
+
+	tdRaw := d.makeTdRaw(tagHttpGetIncomplete)
+	if !bytes.Equal(tdRaw.stationPubkey, pubkey) {
+		t.Fatalf("Expected station pubkey %v, got %v", pubkey, tdRaw.stationPubkey)
+	}
+	if tdRaw.assets.GetGeneration() != generation {
+		t.Fatalf("Expected generation %v, got %v", generation, tdRaw.assets.GetGeneration())
+	}
+
+	// ClientConf updates must not leak into global Assets()
+	newGeneration := uint32(43)
+	err := tdRaw.assets.SetClientConf(&pb.ClientConf{Generation: &newGeneration})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.getAssets().GetGeneration() != newGeneration {
+		t.Fatalf("Expected generation %v, got %v", newGeneration, d.getAssets().GetGeneration())
+	}
+	if Assets().GetGeneration() != globalGeneration {
+		t.Fatalf("Global generation changed: %v -> %v", globalGeneration, Assets().GetGeneration())
+	}
+}
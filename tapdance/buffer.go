@@ -0,0 +1,401 @@
+package tapdance
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Buffers of flow read and write paths are recycled through pools of two size classes:
+// small buffers fit typical messages, and large ones fit any raw data message with header.
+const (
+	smallBufferSize = 2 * 1024
+	largeBufferSize = 6 + 32*1024
+)
+
+// pooledBuffer holds a slice, that goes back to its pool, once released.
+// Pointers are pooled, so that Get and Put don't allocate.
+type pooledBuffer struct {
+	b []byte
+}
+
+var smallBufferPool = sync.Pool{New: func() interface{} {
+	return &pooledBuffer{b: make([]byte, smallBufferSize)}
+}}
+
+var largeBufferPool = sync.Pool{New: func() interface{} {
+	return &pooledBuffer{b: make([]byte, largeBufferSize)}
+}}
+
+// returns buffer of length n, which comes from the pool, unless n exceeds largeBufferSize
+func getBuffer(n int) *pooledBuffer {
+	var buf *pooledBuffer
+	switch {
+	case n <= smallBufferSize:
+		buf = smallBufferPool.Get().(*pooledBuffer)
+	case n <= largeBufferSize:
+		buf = largeBufferPool.Get().(*pooledBuffer)
+	default:
+		return &pooledBuffer{b: make([]byte, n)}
+	}
+	buf.b = buf.b[:n]
+	return buf
+}
+
+// returns buffer to its pool. Neither buffer, nor its slice may be used afterwards.
+func (buf *pooledBuffer) release() {
+	switch cap(buf.b) {
+	case smallBufferSize:
+		smallBufferPool.Put(buf)
+	case largeBufferSize:
+		largeBufferPool.Put(buf)
+	}
+}
+
+// Writes msgs with a single Write, copying them into a pooled buffer first. Separate Writes
+// to a TLS connection would put every message in its own record, and TLS connections don't
+// implement writev, so net.Buffers.WriteTo would write messages one by one anyway.
+func writeMsgs(w io.Writer, msgs net.Buffers) (int, error) {
+	size := 0
+	for _, msg := range msgs {
+		size += len(msg)
+	}
+	buf := getBuffer(size)
+	buf.b = buf.b[:0]
+	for _, msg := range msgs {
+		buf.b = append(buf.b, msg...)
+	}
+	n, err := w.Write(buf.b)
+	buf.release()
+	return n, err
+}
+
+// readBuffer passes data from the reader engine to Read().
+// Reads block until there is data, buffer is unblocked, or cancel channel, provided to Read,
+// is closed (e.g. on read deadline). If buffer is bounded, Writes block, while it's full,
+// so that slow reader slows down the writer, instead of making buffer grow.
+// Data is kept in a queue of pooled chunks: reader engine hands over chunks it read into,
+// so that data is copied only once, into the slice given to Read.
+type readBuffer struct {
+	sync.Mutex
+	chunks []*pooledBuffer // queued data; chunks before head are already read
+	head   int
+	offset int // how much of chunks[head] is already read
+	size   int // buffered bytes
+	limit  int // max buffered bytes, 0 if unbounded
+
+	hasData     chan struct{} // signals that data was written
+	hasSpace    chan struct{} // signals that data was read
+	waiting     int32         // set, while Write waits for space, accessed atomically
+	unblocked   chan struct{} // closed once buffer is unblocked
+	unblockOnce sync.Once
+	unblockErr  error // returned by Reads, once buffer is unblocked and drained
+}
+
+// limit is the max number of buffered bytes, 0 means unbounded
+func makeReadBuffer(limit int) *readBuffer {
+	return &readBuffer{
+		limit:     limit,
+		hasData:   make(chan struct{}, 1),
+		hasSpace:  make(chan struct{}, 1),
+		unblocked: make(chan struct{}),
+	}
+}
+
+// Read reads available data. If there is none, it blocks until data is written, or buffer is
+// unblocked, in which case io.EOF or error, given to UnblockWithError, is returned, or cancel
+// is closed, in which case timeoutError is returned.
+func (b *readBuffer) Read(p []byte, cancel <-chan struct{}) (int, error) {
+	for {
+		if isClosedChan(cancel) {
+			return 0, timeoutError{}
+		}
+		b.Lock()
+		if b.size > 0 {
+			n := 0
+			for n < len(p) && b.head < len(b.chunks) {
+				chunk := b.chunks[b.head]
+				copied := copy(p[n:], chunk.b[b.offset:])
+				n += copied
+				b.offset += copied
+				if b.offset == len(chunk.b) {
+					chunk.release()
+					b.chunks[b.head] = nil
+					b.head++
+					b.offset = 0
+				}
+			}
+			b.size -= n
+			if b.head == len(b.chunks) {
+				b.chunks = b.chunks[:0]
+				b.head = 0
+			}
+			b.Unlock()
+			select {
+			case b.hasSpace <- struct{}{}:
+			default:
+			}
+			return n, nil
+		}
+		b.Unlock()
+		if isClosedChan(b.unblocked) {
+			return 0, b.unblockErr
+		}
+
+		select {
+		case <-b.hasData:
+		case <-b.unblocked:
+		case <-cancel:
+		}
+	}
+}
+
+// Write appends copy of p to the buffer. If buffer is bounded, it blocks, until all of p fits.
+// Returns io.ErrClosedPipe, if buffer was unblocked, and timeoutError, if cancel was closed.
+func (b *readBuffer) Write(p []byte, cancel <-chan struct{}) (int, error) {
+	written := 0
+	for {
+		b.Lock()
+		if isClosedChan(b.unblocked) {
+			b.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		space := len(p) - written
+		if b.limit > 0 {
+			space = minInt(space, b.limit-b.size)
+		}
+		if space > 0 {
+			b.appendLocked(p[written : written+space])
+			written += space
+		}
+		b.Unlock()
+		if space > 0 {
+			b.signalData()
+		}
+		if written == len(p) {
+			return written, nil
+		}
+		if !b.waitForSpace(cancel) {
+			return written, timeoutError{}
+		}
+	}
+}
+
+// push queues chunk without copying it, and takes ownership of it: chunk is released once
+// read, or if push fails. If buffer is bounded, push blocks, until chunk fits, but empty
+// buffer takes a chunk of any size. Returns same errors as Write.
+func (b *readBuffer) push(chunk *pooledBuffer, cancel <-chan struct{}) error {
+	for {
+		b.Lock()
+		if isClosedChan(b.unblocked) {
+			b.Unlock()
+			chunk.release()
+			return io.ErrClosedPipe
+		}
+		if b.limit <= 0 || b.size == 0 || b.size+len(chunk.b) <= b.limit {
+			if cap(chunk.b) == smallBufferSize && b.fitsLastChunk(len(chunk.b)) {
+				// merge small chunks, so that their spare capacity doesn't add up
+				b.appendLocked(chunk.b)
+				chunk.release()
+			} else {
+				b.size += len(chunk.b)
+				b.enqueueLocked(chunk)
+			}
+			b.Unlock()
+			b.signalData()
+			return nil
+		}
+		b.Unlock()
+		if !b.waitForSpace(cancel) {
+			chunk.release()
+			return timeoutError{}
+		}
+	}
+}
+
+// copies p into spare capacity of the last chunk and new pooled chunks
+func (b *readBuffer) appendLocked(p []byte) {
+	b.size += len(p)
+	if b.fitsLastChunk(0) {
+		last := b.chunks[len(b.chunks)-1]
+		n := minInt(len(p), cap(last.b)-len(last.b))
+		last.b = append(last.b, p[:n]...)
+		p = p[n:]
+	}
+	for len(p) > 0 {
+		chunk := getBuffer(minInt(len(p), largeBufferSize))
+		p = p[copy(chunk.b, p):]
+		b.enqueueLocked(chunk)
+	}
+}
+
+// returns true, if last queued chunk has more than n bytes of spare capacity
+func (b *readBuffer) fitsLastChunk(n int) bool {
+	if b.head == len(b.chunks) {
+		return false
+	}
+	last := b.chunks[len(b.chunks)-1]
+	return cap(last.b)-len(last.b) > n
+}
+
+func (b *readBuffer) enqueueLocked(chunk *pooledBuffer) {
+	if b.head > 0 && len(b.chunks) == cap(b.chunks) {
+		// reuse space of chunks, that were read, instead of growing
+		n := copy(b.chunks, b.chunks[b.head:])
+		for i := n; i < len(b.chunks); i++ {
+			b.chunks[i] = nil
+		}
+		b.chunks = b.chunks[:n]
+		b.head = 0
+	}
+	b.chunks = append(b.chunks, chunk)
+}
+
+func (b *readBuffer) signalData() {
+	select {
+	case b.hasData <- struct{}{}:
+	default:
+	}
+}
+
+// waits for Read to free up space. Returns false, if cancel was closed first.
+func (b *readBuffer) waitForSpace(cancel <-chan struct{}) bool {
+	atomic.StoreInt32(&b.waiting, 1)
+	defer atomic.StoreInt32(&b.waiting, 0)
+	select {
+	case <-b.hasSpace:
+	case <-b.unblocked:
+	case <-cancel:
+		return false
+	}
+	return true
+}
+
+// returns true, while Write waits for reader to free up space
+func (b *readBuffer) full() bool {
+	return atomic.LoadInt32(&b.waiting) != 0
+}
+
+// Close drops buffered data, and makes all pending and future Reads return io.EOF.
+func (b *readBuffer) Close() {
+	b.Lock()
+	for _, chunk := range b.chunks[b.head:] {
+		chunk.release()
+	}
+	b.chunks = nil
+	b.head = 0
+	b.offset = 0
+	b.size = 0
+	b.Unblock()
+	b.Unlock()
+}
+
+// Unblock makes all pending and future Reads return io.EOF, once buffered data is read.
+func (b *readBuffer) Unblock() {
+	b.UnblockWithError(io.EOF)
+}
+
+// UnblockWithError makes all pending and future Reads return err, once buffered data is read.
+func (b *readBuffer) UnblockWithError(err error) {
+	b.unblockOnce.Do(func() {
+		b.unblockErr = err
+		close(b.unblocked)
+	})
+}
+
+// writeQueue collects data of asynchronous Writes, until writer engine takes it to send.
+// Data of many small Writes is taken at once, so it is coalesced into larger messages.
+type writeQueue struct {
+	sync.Mutex
+	putMu    sync.Mutex // keeps data of concurrent Writes from interleaving
+	buf      []byte     // queued data
+	spare    []byte     // recycled buffer, that queue switches to, once engine takes buf
+	inFlight int        // bytes, that engine took, but hasn't sent yet
+	limit    int        // max bytes, queued and in flight
+	err      error      // why sending queued data failed, reported by put
+
+	ready    chan struct{} // signals that data was queued
+	hasSpace chan struct{} // signals that queued data was sent
+}
+
+func makeWriteQueue(limit int) *writeQueue {
+	return &writeQueue{
+		limit:    limit,
+		ready:    make(chan struct{}, 1),
+		hasSpace: make(chan struct{}, 1),
+	}
+}
+
+// put queues copy of p, blocking while queue is full. If sending of earlier data failed,
+// returns that error instead. Returns timeoutError, if cancel is closed, and
+// io.ErrClosedPipe, if closed is closed, before all of p was queued.
+func (q *writeQueue) put(p []byte, cancel, closed <-chan struct{}) (int, error) {
+	q.putMu.Lock()
+	defer q.putMu.Unlock()
+	written := 0
+	for {
+		q.Lock()
+		if q.err != nil {
+			err := q.err
+			q.Unlock()
+			return written, err
+		}
+		space := minInt(len(p)-written, q.limit-len(q.buf)-q.inFlight)
+		if space > 0 {
+			q.buf = append(q.buf, p[written:written+space]...)
+			written += space
+		}
+		q.Unlock()
+		if space > 0 {
+			select {
+			case q.ready <- struct{}{}:
+			default:
+			}
+		}
+		if written == len(p) {
+			return written, nil
+		}
+
+		select {
+		case <-q.hasSpace:
+		case <-cancel:
+			return written, timeoutError{}
+		case <-closed:
+			return written, io.ErrClosedPipe
+		}
+	}
+}
+
+// take returns all queued data, which engine has to pass back to done, once it's sent
+func (q *writeQueue) take() []byte {
+	q.Lock()
+	defer q.Unlock()
+	data := q.buf
+	q.buf = q.spare[:0]
+	q.spare = nil
+	q.inFlight = len(data)
+	return data
+}
+
+// done recycles data, returned by take, and records error of sending it, if any
+func (q *writeQueue) done(data []byte, err error) {
+	q.Lock()
+	q.spare = data[:0]
+	q.inFlight = 0
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.Unlock()
+	select {
+	case q.hasSpace <- struct{}{}:
+	default:
+	}
+}
+
+// returns error of sending queued data, if any
+func (q *writeQueue) failure() error {
+	q.Lock()
+	defer q.Unlock()
+	return q.err
+}
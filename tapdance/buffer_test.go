@@ -0,0 +1,136 @@
+package tapdance
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestReadBuffer_Bounded(t *testing.T) {
+	b := makeReadBuffer(10)
+	written := make(chan struct{})
+	go func() {
+		b.Write([]byte("0123456789abcdef"), nil)
+		close(written)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !b.full() || isClosedChan(written) {
+		t.Fatal("Write didn't block on full buffer")
+	}
+	received := make([]byte, 16)
+	if _, err := io.ReadFull(readerFunc(func(p []byte) (int, error) {
+		return b.Read(p, nil)
+	}), received); err != nil {
+		t.Fatal(err)
+	}
+	<-written
+	if string(received) != "0123456789abcdef" {
+		t.Fatalf("Received %q", received)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	b.Write(make([]byte, 10), nil)
+	if _, err := b.Write([]byte("x"), cancel); err != (timeoutError{}) {
+		t.Fatalf("Expected timeout on full buffer, got %v", err)
+	}
+}
+
+// chunks are handed over as is, and small ones share capacity
+func TestReadBuffer_Push(t *testing.T) {
+	b := makeReadBuffer(4000)
+	var sent []byte
+	for _, size := range []int{100, 200, 3000, 50} {
+		chunk := getBuffer(size)
+		rand.Read(chunk.b)
+		sent = append(sent, chunk.b...)
+		if err := b.push(chunk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.chunks) != 2 {
+		t.Fatalf("Expected small chunks to be merged, got %d chunks", len(b.chunks))
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if err := b.push(getBuffer(1000), cancel); err != (timeoutError{}) {
+		t.Fatalf("Expected timeout on full buffer, got %v", err)
+	}
+	received := make([]byte, len(sent))
+	if _, err := io.ReadFull(readerFunc(func(p []byte) (int, error) {
+		return b.Read(p[:minInt(len(p), 70)], nil)
+	}), received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("Pushed data was corrupted")
+	}
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// station keeps sending data, while application doesn't read: memory has to stay flat
+func TestFlow_StalledReader(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(1 << 30)
+	tdRaw.receiveBufferSize = 64 * 1024
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	sent := make([]byte, 16<<20)
+	rand.Read(sent)
+	received := make([]byte, len(sent))
+	var memBefore runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memBefore)
+
+	go func() {
+		for i := 0; i < len(sent); i += 16 * 1024 {
+			if _, err := flow.Write(sent[i : i+16*1024]); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; !flow.readBuf.full(); i++ {
+		if i == 1000 {
+			t.Fatal("Receive buffer isn't full under stalled reader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond) // let station keep pushing
+	var memStalled runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memStalled)
+	if growth := int64(memStalled.HeapAlloc) - int64(memBefore.HeapAlloc); growth > 4<<20 {
+		t.Fatalf("Heap grew by %d bytes under stalled reader", growth)
+	}
+
+	// nothing is lost, once application reads again
+	flow.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if _, err = io.ReadFull(flow, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("Data was corrupted after stall")
+	}
+}
@@ -2,11 +2,11 @@ package tapdance
 
 import (
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"github.com/refraction-networking/utls"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,6 +28,20 @@ const deadlineTCPtoDecoyMax = deadlineConnectTDStationMax
 const waitForFINDieMin = 2 * deadlineConnectTDStationMin
 const waitForFINDieMax = 2 * deadlineConnectTDStationMax
 
+// how much received data flow buffers, while application doesn't read it
+const defaultReceiveBufferSize = 1 << 20
+
+// size of padding in keepalives, which are smaller than transitions, since they are more frequent
+const keepalivePaddingMin = 50
+const keepalivePaddingMax = 300
+
+// how long dormant flow stays without connection to decoy by default. Station can't wake
+// the client up, so data, sent by destination meanwhile, waits for that long
+const defaultMaxDormant = time.Minute
+
+// delay between starting concurrent dials, when racing decoys
+const defaultRaceStagger = 250 * time.Millisecond
+
 const maxInt16 = int16(^uint16(0) >> 1) // max msg size -> might have to chunk
 //const minInt16 = int16(-maxInt16 - 1)
 
@@ -70,8 +84,6 @@ func (m *msgType) Str() string {
 	}
 }
 
-var errMsgClose = errors.New("MSG CLOSE")
-var errNotImplemented = errors.New("Not implemented")
 
 type tdTagType int8
 
@@ -131,16 +143,22 @@ func SetTlsLogFilename(filename string) error {
 }
 
 func WriteTlsLog(clientRandom, masterSecret []byte) error {
+	return appendTlsLog([]byte(fmt.Sprintf("CLIENT_RANDOM %s %s\n",
+		hex.EncodeToString(clientRandom),
+		hex.EncodeToString(masterSecret))))
+}
+
+// appends line in NSS key log format to tlsSecretLog, if it is set
+func appendTlsLog(line []byte) error {
 	if tlsSecretLog != "" {
 		f, err := os.OpenFile(tlsSecretLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			return err
 		}
 
-		_, err = fmt.Fprintf(f, "CLIENT_RANDOM %s %s\n",
-			hex.EncodeToString(clientRandom),
-			hex.EncodeToString(masterSecret))
+		_, err = f.Write(line)
 		if err != nil {
+			f.Close()
 			return err
 		}
 
@@ -149,8 +167,38 @@ func WriteTlsLog(clientRandom, masterSecret []byte) error {
 	return nil
 }
 
+// TLS 1.3 has no master secret to put in the tag. Instead, tag carries application
+// traffic secrets, which utls only exposes through Config.KeyLogWriter.
+// trafficSecretsLog is set as KeyLogWriter of every connection to decoy, captures those
+// secrets and forwards all lines to tlsSecretLog.
+type trafficSecretsLog struct {
+	client []byte // client_application_traffic_secret_0
+	server []byte // server_application_traffic_secret_0
+}
+
+func (l *trafficSecretsLog) Write(line []byte) (int, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) == 3 {
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return 0, err
+		}
+		switch fields[0] {
+		case "CLIENT_TRAFFIC_SECRET_0":
+			l.client = secret
+		case "SERVER_TRAFFIC_SECRET_0":
+			l.server = secret
+		}
+	}
+	if err := appendTlsLog(line); err != nil {
+		Logger().Warningf("Failed to write TLS secret log: %s", err)
+	}
+	return len(line), nil
+}
+
 // List of actually supported ciphers(not a list of offered ciphers!)
-// Essentially all working AES_GCM_128 ciphers
+// Essentially all working AEAD ciphers: AES_GCM and CHACHA20_POLY1305 of TLS 1.2, and all of TLS 1.3
+// (TLS 1.3 is only used, if station supports it, see Dialer.AllowTLS13)
 var tapDanceSupportedCiphers = []uint16{
 	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
@@ -158,12 +206,27 @@ var tapDanceSupportedCiphers = []uint16{
 	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
 	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_AES_128_GCM_SHA256,
+	tls.TLS_AES_256_GCM_SHA384,
+	tls.TLS_CHACHA20_POLY1305_SHA256,
 }
 
-// How much time to sleep on trying to connect to decoys to prevent overwhelming them
-func sleepBeforeConnect(attempt int) (waitTime <-chan time.Time) {
-	if attempt >= 6 { // return nil for first 6 attempts
-		waitTime = time.After(time.Second * 1)
+// Size of a slot for each of TLS 1.3 traffic secrets in the tag.
+// Secrets are as long as the hash of the cipher suite: 32 bytes for SHA256 suites
+// are zero-padded, so tag layout doesn't depend on the negotiated suite.
+const tls13SecretSlotLen = 48
+
+func cipherIsSupported(id uint16) bool {
+	for _, c := range tapDanceSupportedCiphers {
+		if c == id {
+			return true
+		}
 	}
-	return
+	return false
 }
+
+// ClientHello, that is used by default, and as a fallback, when another parrot
+// can't negotiate a cipher suite, supported by TapDance
+var defaultClientHelloID = tls.HelloChrome_62
@@ -2,9 +2,10 @@ package tapdance
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"net"
 	"strconv"
+	"time"
 )
 
 // DualConn is composed of 2 separate TapdanceFlowConn.
@@ -19,15 +20,10 @@ type DualConn struct {
 }
 
 // returns TapDance connection that utilizes 2 flows underneath: reader and writer
-func dialSplitFlow(ctx context.Context, customDialer func(context.Context, string, string) (net.Conn, error),
-	covert string) (net.Conn, error) {
+func dialSplitFlow(ctx context.Context, d *Dialer, covert string) (net.Conn, error) {
 	dualConn := DualConn{sessionId: sessionsTotal.GetAndInc()}
-	stationPubkey := Assets().GetPubkey()
 
-	rawRConn := makeTdRaw(tagHttpGetIncomplete, stationPubkey[:])
-	if customDialer != nil {
-		rawRConn.TcpDialer = customDialer
-	}
+	rawRConn := d.makeTdRaw(tagHttpGetIncomplete)
 	rawRConn.sessionId = dualConn.sessionId
 	rawRConn.strIdSuffix = "R"
 
@@ -52,11 +48,7 @@ func dialSplitFlow(ctx context.Context, customDialer func(context.Context, strin
 		return nil, err
 	}
 
-	rawWConn := makeTdRaw(tagHttpPostIncomplete,
-		stationPubkey[:])
-	if customDialer != nil {
-		rawRConn.TcpDialer = customDialer
-	}
+	rawWConn := d.makeTdRaw(tagHttpPostIncomplete)
 	rawWConn.sessionId = dualConn.sessionId
 	rawWConn.strIdSuffix = "W"
 	rawWConn.decoySpec = rawRConn.decoySpec
@@ -79,28 +71,23 @@ func dialSplitFlow(ctx context.Context, customDialer func(context.Context, strin
 		dualConn.writerConn.closeWithErrorOnce(err)
 		return nil, err
 	}
-	/* // TODO: yield confirmation
-	writerConn.yieldConfirmed = make(chan struct{})
-	go func() {
-		time.Sleep(time.Duration(getRandInt(1234, 5432)) * time.Millisecond)
-		Logger().Infoln(dualConn.idStr() + " faking yield confirmation!")
-		writerConn.yieldConfirmed <- struct{}{}
-	}()
-	err = writerConn.WaitForYieldConfirmation()
-	if err != nil {
-		dualConn.readerConn.Close()
-		writerConn.Close()
-		return nil, err
+	// don't let application upload, until station paired the flows, otherwise data would
+	// go nowhere
+	if d.WaitForYieldConfirmation {
+		if err = dualConn.readerConn.waitForYieldConfirmation(ctx); err != nil {
+			dualConn.readerConn.closeWithErrorOnce(err)
+			dualConn.writerConn.closeWithErrorOnce(err)
+			return nil, err
+		}
 	}
-	*/
 	go func() {
 		select {
 		case <-dualConn.readerConn.closed:
-			dualConn.writerConn.closeWithErrorOnce(errors.New("in paired readerConn: " +
-				dualConn.readerConn.closeErr.Error()))
+			dualConn.writerConn.closeWithErrorOnce(fmt.Errorf("in paired readerConn: %w",
+				dualConn.readerConn.closeErr))
 		case <-dualConn.writerConn.closed:
-			dualConn.readerConn.closeWithErrorOnce(errors.New("in paired writerConn: " +
-				dualConn.writerConn.closeErr.Error()))
+			dualConn.readerConn.closeWithErrorOnce(fmt.Errorf("in paired writerConn: %w",
+				dualConn.writerConn.closeErr))
 		}
 	}()
 	return &dualConn, nil
@@ -113,6 +100,37 @@ func (tdConn *DualConn) Write(b []byte) (int, error) {
 	return tdConn.writerConn.Write(b)
 }
 
+// Close tells station to close the session via writer flow, and closes both flows.
+func (tdConn *DualConn) Close() error {
+	err := tdConn.writerConn.Close()
+	tdConn.readerConn.Close()
+	return err
+}
+
+// CloseRead shuts down the reading side of the connection, see TapdanceFlowConn.CloseRead.
+func (tdConn *DualConn) CloseRead() error {
+	return tdConn.readerConn.CloseRead()
+}
+
 func (tdConn *DualConn) idStr() string {
 	return "[Session " + strconv.FormatUint(tdConn.sessionId, 10) + "]"
 }
+
+// SetDeadline sets the read deadline on reader flow and the write deadline on writer flow.
+func (tdConn *DualConn) SetDeadline(t time.Time) error {
+	tdConn.readerConn.SetReadDeadline(t)
+	tdConn.writerConn.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future Read calls.
+// A zero value for t means Read will not time out.
+func (tdConn *DualConn) SetReadDeadline(t time.Time) error {
+	return tdConn.readerConn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for future Write calls.
+// A zero value for t means Write will not time out.
+func (tdConn *DualConn) SetWriteDeadline(t time.Time) error {
+	return tdConn.writerConn.SetWriteDeadline(t)
+}
@@ -0,0 +1,123 @@
+package tapdance
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// makes Dialer, that dials split flows through the station, and waits for yield confirmation
+func (station *testStation) makeSplitDialer() *Dialer {
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	decoy := tdRaw.decoySpec
+	decoy.Timeout = proto.Uint32(60000)
+	decoy.Tcpwin = proto.Uint32(16000)
+	d := &Dialer{SplitFlows: true, WaitForYieldConfirmation: true, TcpDialer: tdRaw.TcpDialer}
+	d.assets = makeAssetsFromConf(&pb.ClientConf{
+		DecoyList: &pb.DecoyList{TlsDecoys: []*pb.TLSDecoySpec{&decoy}},
+	}, station.roots)
+	return d
+}
+
+func TestDualConn_YieldConfirmation(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+
+	conn, err := dialSplitFlow(context.Background(), station.makeSplitDialer(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	station.mu.Lock()
+	events := strings.Join(station.events, "\n")
+	station.mu.Unlock()
+	expected := strings.Join([]string{
+		"picked up 0",
+		"C2S_YIELD_UPLOAD 0",
+		"picked up 1",
+		"C2S_ACQUIRE_UPLOAD 1",
+		"confirmed yield 1",
+	}, "\n")
+	if events != expected {
+		t.Fatalf("Expected events:\n%s\ngot:\n%s", expected, events)
+	}
+
+	// uploaded data comes back through the reader flow
+	sent := []byte("split flows are paired")
+	if _, err = conn.Write(sent); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, len(sent))
+	if _, err = io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatalf("Expected %q, got %q", sent, received)
+	}
+
+	// repeated confirmation is harmless
+	reader := conn.(*DualConn).readerConn
+	confirmTransition := pb.S2C_Transition_S2C_CONFIRM_YIELD
+	if err = reader.processProto(pb.StationToClient{StateTransition: &confirmTransition}); err != nil {
+		t.Fatal(err)
+	}
+	// but flows, that didn't yield upload, aren't expecting it
+	writer := conn.(*DualConn).writerConn
+	if err = writer.processProto(pb.StationToClient{StateTransition: &confirmTransition}); err == nil {
+		t.Fatal("Upload flow accepted yield confirmation")
+	}
+}
+
+func TestDualConn_YieldConfirmationTimeout(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+	station.noYieldConf = true
+
+	defer func(timeout time.Duration) { yieldConfirmationTimeout = timeout }(yieldConfirmationTimeout)
+	yieldConfirmationTimeout = 500 * time.Millisecond
+	start := time.Now()
+	conn, err := dialSplitFlow(context.Background(), station.makeSplitDialer(), "")
+	if err == nil {
+		conn.Close()
+		t.Fatal("Split flows proceeded without yield confirmation")
+	}
+	if !strings.Contains(err.Error(), "yield confirmation timeout") {
+		t.Fatalf("Expected yield confirmation timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Dial took %v", elapsed)
+	}
+
+	// dial gives up, once context is done
+	station = startTestStation(t)
+	defer station.stop()
+	station.noYieldConf = true
+	yieldConfirmationTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = dialSplitFlow(ctx, station.makeSplitDialer(), "")
+	if err == nil || !strings.Contains(err.Error(), "yield confirmation") {
+		t.Fatalf("Expected yield confirmation to be cancelled, got %v", err)
+	}
+
+	// by default, stations without confirmation support may be used
+	station = startTestStation(t)
+	defer station.stop()
+	station.noYieldConf = true
+	dialer := station.makeSplitDialer()
+	dialer.WaitForYieldConfirmation = false
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err = dialSplitFlow(ctx, dialer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -1,6 +1,5 @@
 /*
 TODO: It probably should have read flow that reads messages and says STAAAHP to channel when read
-TODO: here we actually can avoid reconnecting if idle for too long
 TODO: confirm that all writes are recorded towards data limit
 */
 
@@ -8,33 +7,45 @@ package tapdance
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/sergeyfrolov/bsbuffer"
+	"github.com/refraction-networking/utls"
 	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+	"github.com/sirupsen/logrus"
 )
 
 // TapdanceFlowConn represents single TapDance flow.
 type TapdanceFlowConn struct {
 	tdRaw *tdRawConn
 
-	bsbuf     *bsbuffer.BSBuffer
-	recvbuf   []byte
+	readBuf   *readBuffer
 	headerBuf [6]byte
 
 	writeSliceChan    chan []byte
 	writeResultChan   chan ioOpResult
+	writeQueue        *writeQueue // collects asynchronous Writes, nil if Writes are synchronous
 	writtenBytesTotal int
 
-	yieldConfirmed chan struct{} // used by flowConn to signal that flow was picked up
+	sessionCloseChan chan chan error // asks writer engine to send C2S_SESSION_CLOSE, and reply
+	writeClosed      int32           // set once session close is requested, accessed atomically
+	readClosed       int32           // set by CloseRead, accessed atomically
+
+	uploadLimiters   rateLimiters // applied by writer engine to each chunk of data it sends
+	downloadLimiters rateLimiters // applied by reader engine, before data is passed to Read
+
+	readDeadline  *deadline // set by user, unlike read deadline of tdRaw.tlsConn
+	writeDeadline *deadline
+
+	yieldConfirmed chan struct{} // closed, once station paired read-only flow with upload flow
 
 	readOnly         bool // if readOnly -- we don't need to wait for write engine to stop
 	reconnectSuccess chan bool
@@ -42,6 +53,35 @@ type TapdanceFlowConn struct {
 
 	finSent bool // used only by reader to know if it has already scheduled reconnect
 
+	info connInfo // what Info reports
+
+	// make-before-break reconnects, see Dialer.OverlapReconnects
+	reconnectDeadline time.Time              // when current connection has to be abandoned
+	overlapResult     chan overlapDialResult // set by reader, while next connection is dialed
+	overlapRequested  bool                   // set by writer, once it asked to dial in advance
+	reconnectUrgently chan struct{}          // signals reader not to wait for the deadline
+	drainMu           sync.Mutex             // protects drainConn
+	drainConn         *tls.UConn             // previous connection, read until station closes it
+
+	// TLS connection, that writer engine is writing data of Write to, so that Write could
+	// interrupt it, once deadline is exceeded
+	writingMu        sync.Mutex
+	writingTo        net.Conn
+	writeInterrupted bool
+	tlsWriteBroken   int32 // set by writer engine after interrupted write, accessed atomically
+
+	// lazy reconnects of idle flows, see Dialer.IdleTimeout
+	lastActivity int64         // UnixNano of the last Write or received data, accessed atomically
+	dormantSince time.Time     // set by reader, when flow is about to go dormant, zero otherwise
+	wake         chan struct{} // signals dormant flow to reconnect
+
+	// liveness probing, see Dialer.KeepaliveInterval
+	lastReceived    int64     // UnixNano of the last message from station, accessed atomically
+	keepaliveSentAt time.Time // set by writer, while keepalive is unanswered, zero otherwise
+	// set by writer, once station answered a keepalive. Until then, station may be one,
+	// that doesn't answer keepalives at all, and unanswered keepalives aren't fatal.
+	keepaliveAnswered bool
+
 	closed    chan struct{}
 	closeOnce sync.Once
 	closeErr  error
@@ -66,19 +106,30 @@ func NewTapDanceConn() (net.Conn, error) {
 func makeTdFlow(flow flowType, tdRaw *tdRawConn, covert string) (*TapdanceFlowConn, error) {
 	if tdRaw == nil {
 		// raw TapDance connection is not given, make a new one
-		stationPubkey := Assets().GetPubkey()
-		remoteConnId := make([]byte, 16)
-		rand.Read(remoteConnId[:])
-		tdRaw = makeTdRaw(tagHttpGetIncomplete,
-			stationPubkey[:])
-		tdRaw.covert = covert
+		tdRaw = makeTdRaw(tagHttpGetIncomplete, Assets())
 		tdRaw.sessionId = sessionsTotal.GetAndInc()
 	}
+	tdRaw.covert = covert
 
 	flowConn := &TapdanceFlowConn{tdRaw: tdRaw}
-	flowConn.bsbuf = bsbuffer.NewBSBuffer()
+	flowConn.readBuf = makeReadBuffer(tdRaw.receiveBufferSize)
+	flowConn.readDeadline = makeDeadline()
+	flowConn.writeDeadline = makeDeadline()
 	flowConn.closed = make(chan struct{})
+	flowConn.reconnectUrgently = make(chan struct{}, 1)
+	flowConn.wake = make(chan struct{}, 1)
+	flowConn.markActivity()
+	flowConn.markReceived()
+	globalRateLimiters.Lock()
+	flowConn.uploadLimiters = makeRateLimiters(tdRaw.uploadRate, tdRaw.uploadLimiter,
+		globalRateLimiters.upload)
+	flowConn.downloadLimiters = makeRateLimiters(tdRaw.downloadRate, tdRaw.downloadLimiter,
+		globalRateLimiters.download)
+	globalRateLimiters.Unlock()
 	flowConn.flowType = flow
+	if flow == flowReadOnly {
+		flowConn.yieldConfirmed = make(chan struct{})
+	}
 	return flowConn, nil
 }
 
@@ -100,6 +151,10 @@ func (flowConn *TapdanceFlowConn) DialContext(ctx context.Context) error {
 		flowConn.closeWithErrorOnce(err)
 		return err
 	}
+	// set before engines start, so that reader doesn't override deadline, that writer sets
+	// to reconnect early
+	flowConn.updateReadDeadline()
+	flowConn.publishInfo(false)
 
 	switch flowConn.flowType {
 	case flowUpload:
@@ -110,6 +165,10 @@ func (flowConn *TapdanceFlowConn) DialContext(ctx context.Context) error {
 		flowConn.reconnectStarted = make(chan struct{})
 		flowConn.writeSliceChan = make(chan []byte)
 		flowConn.writeResultChan = make(chan ioOpResult)
+		flowConn.sessionCloseChan = make(chan chan error)
+		if flowConn.tdRaw.writeQueueSize > 0 {
+			flowConn.writeQueue = makeWriteQueue(flowConn.tdRaw.writeQueueSize)
+		}
 		go flowConn.spawnWriterEngine()
 		return nil
 	case flowReadOnly:
@@ -126,14 +185,26 @@ type ioOpResult struct {
 }
 
 func (flowConn *TapdanceFlowConn) schedReconnectNow() {
+	select {
+	case flowConn.reconnectUrgently <- struct{}{}:
+	default:
+	}
 	flowConn.tdRaw.tlsConn.SetReadDeadline(time.Now())
 }
 
-// returns bool indicating success of reconnect
-func (flowConn *TapdanceFlowConn) awaitReconnect() bool {
-	defer func() { flowConn.writtenBytesTotal = 0 }()
+// returns bool indicating success of reconnect.
+// If timeout channel is closed first, calls onTimeout once and keeps waiting.
+func (flowConn *TapdanceFlowConn) awaitReconnect(timeout <-chan struct{}, onTimeout func()) bool {
+	defer func() {
+		flowConn.writtenBytesTotal = 0
+		flowConn.overlapRequested = false
+		flowConn.keepaliveSentAt = time.Time{}
+	}()
 	for {
 		select {
+		case <-timeout:
+			onTimeout()
+			timeout = nil
 		case <-flowConn.reconnectStarted:
 		case <-flowConn.closed:
 			return false
@@ -148,62 +219,230 @@ func (flowConn *TapdanceFlowConn) awaitReconnect() bool {
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 func (flowConn *TapdanceFlowConn) spawnWriterEngine() {
 	defer close(flowConn.writeResultChan)
-	for {
-		select {
-		case <-flowConn.reconnectStarted:
-			if !flowConn.awaitReconnect() {
-				return
+	var keepaliveTicks <-chan time.Time
+	if flowConn.tdRaw.keepaliveInterval > 0 {
+		ticker := time.NewTicker(flowConn.keepaliveCheckPeriod())
+		defer ticker.Stop()
+		keepaliveTicks = ticker.C
+	}
+	shaper := newShaper(flowConn.tdRaw.shaping)
+	var coverTicks <-chan time.Time
+	if shaper != nil && shaper.coverInterval > 0 {
+		ticker := time.NewTicker(shaper.coverInterval / 4)
+		defer ticker.Stop()
+		coverTicks = ticker.C
+	}
+	canSend := func() int {
+		// checks the upload limit
+		// 6 is max header size (protobufs aren't sent here though)
+		// 1024 is max transition message size
+		return flowConn.tdRaw.UploadLimit -
+			flowConn.writtenBytesTotal - 6 - 1024
+	}
+	// set, once interrupted write left TLS connection unusable
+	interrupted := false
+	// Sends b as raw data, reconnecting whenever upload limit is reached, until deadline.
+	// If deadline passes during reconnect, partial result is given to onTimeout, and sending
+	// stops once flow is reconnected, with timedOut set. Returns ok == false, if engine has
+	// to stop.
+	writeData := func(b []byte, deadline <-chan struct{},
+		onTimeout func(ioOpResult)) (ioResult ioOpResult, timedOut bool, ok bool) {
+		bytesSent := 0
+		for bytesSent < len(b) {
+			if isClosedChan(deadline) {
+				ioResult.err = timeoutError{}
+				break
 			}
-		case <-flowConn.closed:
-			return
-		case b := <-flowConn.writeSliceChan:
-			ioResult := ioOpResult{}
-			bytesSent := 0
-
-			canSend := func() int {
-				// checks the upload limit
-				// 6 is max header size (protobufs aren't sent here though)
-				// 1024 is max transition message size
-				return flowConn.tdRaw.UploadLimit -
-					flowConn.writtenBytesTotal - 6 - 1024
-			}
-			for bytesSent < len(b) {
-				idxToSend := len(b)
-				if idxToSend-bytesSent > canSend() {
+			idxToSend := len(b)
+			if interrupted || idxToSend-bytesSent > canSend() {
+				if interrupted {
+					Logger().Infof("%s reconnecting, since interrupted write broke "+
+						"TLS connection", flowConn.idStr())
+				} else {
 					Logger().Infof("%s reconnecting due to upload limit: "+
 						"idxToSend (%d) - bytesSent(%d) > UploadLimit(%d) - "+
 						"writtenBytesTotal(%d) - 6 - 1024 \n",
 						flowConn.idStr(), idxToSend, bytesSent,
 						flowConn.tdRaw.UploadLimit, flowConn.writtenBytesTotal)
-					flowConn.schedReconnectNow()
-					if !flowConn.awaitReconnect() {
-						return
-					}
 				}
+				interrupted = false
+				flowConn.schedReconnectNow()
+				reconnected := flowConn.awaitReconnect(deadline, func() {
+					timedOut = true
+					onTimeout(ioOpResult{n: ioResult.n, err: timeoutError{}})
+				})
+				if !reconnected || timedOut {
+					return ioResult, timedOut, reconnected
+				}
+				if canSend() <= 0 {
+					ioResult.err = &UploadLimitError{Limit: flowConn.tdRaw.UploadLimit}
+					break
+				}
+			}
+			if Logger().IsLevelEnabled(logrus.DebugLevel) {
+				// dumps are costly, and garbage from them can't be bounded
 				Logger().Debugf("%s WriterEngine: writing\n%s", flowConn.idStr(), hex.Dump(b))
+			}
 
-				if cs := minInt(canSend(), int(maxInt16)); idxToSend-bytesSent > cs {
-					// just reconnected and still can't send: time to chunk
-					idxToSend = bytesSent + cs
-				}
+			if cs := minInt(canSend(), int(maxInt16)); idxToSend-bytesSent > cs {
+				// just reconnected and still can't send: time to chunk
+				idxToSend = bytesSent + cs
+			}
+			if burst := flowConn.uploadLimiters.burst(); burst > 0 &&
+				idxToSend-bytesSent > burst {
+				// rate limiters pass no more than that at once
+				idxToSend = bytesSent + burst
+			}
 
-				// TODO: outerProto limit on data size
-				bufToSend := b[bytesSent:idxToSend]
-				bufToSendWithHeader := getMsgWithHeader(msgRawData, bufToSend) // TODO: optimize!
-				headerSize := len(bufToSendWithHeader) - len(bufToSend)
+			// TODO: outerProto limit on data size
+			bufToSend := b[bytesSent:idxToSend]
+			var msgs net.Buffers
+			var header [rawDataHeaderLen]byte
+			if shaper != nil {
+				record, dataLen := shaper.frame(bufToSend, canSend())
+				bufToSend = bufToSend[:dataLen]
+				msgs = net.Buffers{record}
+				if !shaper.pace(len(record), deadline, flowConn.closed) {
+					ioResult.err = timeoutError{}
+					break
+				}
+				shaper.sent()
+			} else {
+				msgs = net.Buffers{appendMsgHeader(header[:0], msgRawData, len(bufToSend)),
+					bufToSend}
+			}
+			if !flowConn.uploadLimiters.wait(len(bufToSend), deadline, flowConn.closed) {
+				ioResult.err = timeoutError{}
+				break
+			}
+			headerSize := rawDataHeaderLen
+			msgsLen := 0
+			for _, msg := range msgs {
+				msgsLen += len(msg)
+			}
 
-				n, err := flowConn.tdRaw.tlsConn.Write(bufToSendWithHeader)
-				if n >= headerSize {
-					// TODO: that's kinda hacky
-					n -= headerSize
+			// header and data go into the same TLS record
+			if deadline != nil {
+				flowConn.writingMu.Lock()
+				flowConn.writingTo = flowConn.tdRaw.tlsConn
+				flowConn.writingMu.Unlock()
+			}
+			n, err := writeMsgs(flowConn.tdRaw.tlsConn, msgs)
+			if deadline != nil {
+				flowConn.writingMu.Lock()
+				flowConn.writingTo = nil
+				if flowConn.writeInterrupted {
+					// timed out write corrupts TLS state, so connection has to be replaced
+					flowConn.writeInterrupted = false
+					interrupted = true
+					atomic.StoreInt32(&flowConn.tlsWriteBroken, 1)
+					flowConn.schedReconnectNow()
+					if err != nil {
+						err = timeoutError{}
+					}
 				}
-				ioResult.n += n
-				bytesSent += n
-				flowConn.writtenBytesTotal += len(bufToSendWithHeader)
-				if err != nil {
-					ioResult.err = err
-					break
+				flowConn.writingMu.Unlock()
+			}
+			if n >= headerSize {
+				// TODO: that's kinda hacky
+				n = minInt(n-headerSize, len(bufToSend))
+			}
+			if n < len(bufToSend) {
+				flowConn.uploadLimiters.refund(len(bufToSend) - n)
+			}
+			ioResult.n += n
+			bytesSent += n
+			atomic.AddInt64(&flowConn.info.bytesSent, int64(n))
+			flowConn.writtenBytesTotal += msgsLen
+			if err != nil {
+				ioResult.err = err
+				break
+			}
+			if flowConn.overlapping() && !flowConn.overlapRequested &&
+				float64(flowConn.writtenBytesTotal) >
+					overlapPrepareShare*float64(flowConn.tdRaw.UploadLimit) {
+				// ask reader to dial the next connection in advance
+				flowConn.overlapRequested = true
+				flowConn.tdRaw.tlsConn.SetReadDeadline(time.Now())
+			}
+		}
+		return ioResult, false, true
+	}
+	var queueReady <-chan struct{}
+	if flowConn.writeQueue != nil {
+		queueReady = flowConn.writeQueue.ready
+	}
+	// sends data of asynchronous Writes, returns false, if engine has to stop
+	sendQueued := func() bool {
+		data := flowConn.writeQueue.take()
+		ioResult, ok := ioOpResult{}, true
+		if len(data) > 0 {
+			ioResult, _, ok = writeData(data, nil, nil)
+		}
+		flowConn.writeQueue.done(data, ioResult.err)
+		return ok
+	}
+	for {
+		select {
+		case <-coverTicks:
+			record := shaper.coverRecord(canSend())
+			if record == nil || !shaper.pace(len(record), nil, flowConn.closed) {
+				continue
+			}
+			Logger().Debugf("%s WriterEngine: sending %d bytes of cover traffic",
+				flowConn.idStr(), len(record))
+			n, err := flowConn.tdRaw.tlsConn.Write(record)
+			flowConn.writtenBytesTotal += n
+			if err != nil {
+				Logger().Infoln(flowConn.idStr() + " failed to send cover traffic: " + err.Error())
+			}
+			shaper.sent()
+		case <-keepaliveTicks:
+			if err := flowConn.checkLiveness(); err != nil {
+				flowConn.closeWithErrorOnce(err)
+				return
+			}
+		case <-flowConn.reconnectStarted:
+			if !flowConn.awaitReconnect(nil, nil) {
+				return
+			}
+			interrupted = false
+		case <-flowConn.closed:
+			return
+		case result := <-flowConn.sessionCloseChan:
+			var err error
+			if flowConn.writeQueue != nil {
+				// queued data goes first
+				if !sendQueued() {
+					return
 				}
+				err = flowConn.writeQueue.failure()
+			}
+			if err == nil {
+				_, err = flowConn.tdRaw.writeTransition(pb.C2S_Transition_C2S_SESSION_CLOSE)
+			}
+			if err == nil {
+				Logger().Infoln(flowConn.idStr() + " sent SESSION_CLOSE")
+			}
+			result <- err
+		case <-queueReady:
+			if !sendQueued() {
+				return
+			}
+		case b := <-flowConn.writeSliceChan:
+			ioResult, timedOut, ok := writeData(b, flowConn.writeDeadline.wait(),
+				func(partial ioOpResult) {
+					// let Write return, but finish reconnecting anyway
+					select {
+					case flowConn.writeResultChan <- partial:
+					case <-flowConn.closed:
+					}
+				})
+			if !ok {
+				return
+			}
+			if timedOut {
+				continue
 			}
 			select {
 			case flowConn.writeResultChan <- ioResult:
@@ -215,28 +454,38 @@ func (flowConn *TapdanceFlowConn) spawnWriterEngine() {
 }
 
 func (flowConn *TapdanceFlowConn) spawnReaderEngine() {
-	flowConn.updateReadDeadline()
-	flowConn.recvbuf = make([]byte, 1500)
 	for {
 		msgType, msgLen, err := flowConn.readHeader()
 		if err != nil {
 			flowConn.closeWithErrorOnce(err)
 			return
 		}
+		flowConn.markReceived()
 		if msgLen == 0 {
 			continue // wtf?
 		}
 		switch msgType {
 		case msgRawData:
-			buf, err := flowConn.readRawData(msgLen)
+			chunk, err := flowConn.readRawData(msgLen)
 			if err != nil {
+				chunk.release()
 				flowConn.closeWithErrorOnce(err)
 				return
 			}
-			Logger().Debugf("%s ReaderEngine: read\n%s",
-				flowConn.idStr(), hex.Dump(buf))
-			_, err = flowConn.bsbuf.Write(buf)
-			if err != nil {
+			if Logger().IsLevelEnabled(logrus.DebugLevel) {
+				Logger().Debugf("%s ReaderEngine: read\n%s",
+					flowConn.idStr(), hex.Dump(chunk.b))
+			}
+			flowConn.markActivity()
+			atomic.AddInt64(&flowConn.info.bytesReceived, int64(len(chunk.b)))
+			if !flowConn.downloadLimiters.wait(len(chunk.b), nil, flowConn.closed) {
+				chunk.release()
+				return
+			}
+			// hands chunk over without copying; blocks, while application doesn't read,
+			// which stops reading from decoy
+			err = flowConn.readBuf.push(chunk, flowConn.closed)
+			if err != nil && atomic.LoadInt32(&flowConn.readClosed) == 0 {
 				flowConn.closeWithErrorOnce(err)
 				return
 			}
@@ -262,11 +511,46 @@ func (flowConn *TapdanceFlowConn) spawnReaderEngine() {
 // Write writes data to the connection.
 // Write can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
+// If Dialer.WriteQueueSize is set, Write returns once data is queued, and error of sending
+// queued data is returned by one of the subsequent Writes.
 func (flowConn *TapdanceFlowConn) Write(b []byte) (int, error) {
+	if isClosedChan(flowConn.closed) {
+		return 0, flowConn.closeErr
+	}
+	if atomic.LoadInt32(&flowConn.writeClosed) != 0 {
+		return 0, ErrWriteClosed
+	}
+	if isClosedChan(flowConn.writeDeadline.wait()) {
+		return 0, timeoutError{}
+	}
+	flowConn.markActivity()
+	select {
+	case flowConn.wake <- struct{}{}:
+	default:
+	}
+	if flowConn.writeQueue != nil {
+		n, err := flowConn.writeQueue.put(b, flowConn.writeDeadline.wait(), flowConn.closed)
+		if err == io.ErrClosedPipe {
+			err = flowConn.closeErr
+		}
+		return n, err
+	}
 	select {
 	case flowConn.writeSliceChan <- b:
 	case <-flowConn.closed:
 		return 0, flowConn.closeErr
+	case <-flowConn.writeDeadline.wait():
+		return 0, timeoutError{}
+	}
+	select {
+	case r := <-flowConn.writeResultChan:
+		return r.n, r.err
+	case <-flowConn.closed:
+		return 0, flowConn.closeErr
+	case <-flowConn.writeDeadline.wait():
+		// writer engine may be stuck in TLS write, e.g. against full TCP window.
+		// It still holds b, so wait for it to give up.
+		flowConn.interruptWrite()
 	}
 	select {
 	case r := <-flowConn.writeResultChan:
@@ -276,38 +560,50 @@ func (flowConn *TapdanceFlowConn) Write(b []byte) (int, error) {
 	}
 }
 
+// makes TLS write of writer engine, if there is one in progress, return right away
+func (flowConn *TapdanceFlowConn) interruptWrite() {
+	flowConn.writingMu.Lock()
+	defer flowConn.writingMu.Unlock()
+	if flowConn.writingTo != nil {
+		flowConn.writingTo.SetWriteDeadline(time.Now())
+		flowConn.writeInterrupted = true
+	}
+}
+
 func (flowConn *TapdanceFlowConn) Read(b []byte) (int, error) {
-	return flowConn.bsbuf.Read(b)
+	return flowConn.readBuf.Read(b, flowConn.readDeadline.wait())
 }
 
-func (flowConn *TapdanceFlowConn) readRawData(msgLen int) ([]byte, error) {
-	if cap(flowConn.recvbuf) < msgLen {
-		flowConn.recvbuf = make([]byte, msgLen)
-	}
+// Reads raw data into a pooled buffer, which caller has to release or hand over.
+func (flowConn *TapdanceFlowConn) readRawData(msgLen int) (*pooledBuffer, error) {
+	chunk := getBuffer(msgLen)
 	var err error
 	var readBytes int
 	var readBytesTotal int // both header and body
 	// Get the message itself
 	for readBytesTotal < msgLen {
-		readBytes, err = flowConn.tdRaw.tlsConn.Read(flowConn.recvbuf[readBytesTotal:])
+		readBytes, err = flowConn.readConn().Read(chunk.b[readBytesTotal:])
 		readBytesTotal += int(readBytes)
 		if err != nil {
 			err = flowConn.actOnReadError(err)
 			if err != nil {
-				return flowConn.recvbuf[:readBytesTotal], err
+				chunk.b = chunk.b[:readBytesTotal]
+				return chunk, err
 			}
 		}
 	}
-	return flowConn.recvbuf[:readBytesTotal], err
+	return chunk, err
 }
 
 func (flowConn *TapdanceFlowConn) readProtobuf(msgLen int) (msg pb.StationToClient, err error) {
-	rbuf := make([]byte, msgLen)
+	buf := getBuffer(msgLen)
+	defer buf.release() // Unmarshal doesn't keep references to it
+	rbuf := buf.b
 	var readBytes int
 	var readBytesTotal int // both header and body
 	// Get the message itself
 	for readBytesTotal < msgLen {
-		readBytes, err = flowConn.tdRaw.tlsConn.Read(rbuf[readBytesTotal:])
+		readBytes, err = flowConn.readConn().Read(rbuf[readBytesTotal:])
 		readBytesTotal += readBytes
 		if err != nil {
 			err = flowConn.actOnReadError(err)
@@ -329,7 +625,7 @@ func (flowConn *TapdanceFlowConn) readHeader() (msgType msgType, msgLen int, err
 
 	//TODO: check FIN+last data case
 	for readBytesTotal < headerSize {
-		readBytes, err = flowConn.tdRaw.tlsConn.Read(flowConn.headerBuf[readBytesTotal:headerSize])
+		readBytes, err = flowConn.readConn().Read(flowConn.headerBuf[readBytesTotal:headerSize])
 		readBytesTotal += uint32(readBytes)
 		if err != nil {
 			err = flowConn.actOnReadError(err)
@@ -352,7 +648,7 @@ func (flowConn *TapdanceFlowConn) readHeader() (msgType msgType, msgLen int, err
 		msgType = msgProtobuf
 		headerSize += 4
 		for readBytesTotal < headerSize {
-			readBytes, err = flowConn.tdRaw.tlsConn.Read(flowConn.headerBuf[readBytesTotal:headerSize])
+			readBytes, err = flowConn.readConn().Read(flowConn.headerBuf[readBytesTotal:headerSize])
 			readBytesTotal += uint32(readBytes)
 			if err != nil {
 				err = flowConn.actOnReadError(err)
@@ -372,6 +668,10 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 		return nil
 	}
 
+	if flowConn.finishDraining(err) {
+		return nil
+	}
+
 	willScheduleReconnect := false
 	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 		// Timeout is used as a signal to schedule reconnect, as reconnect is indeed time dependent.
@@ -379,6 +679,17 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 		// After EXPECT_RECONNECT and FIN are sent, deadline is used to signal that flow timed out
 		// waiting for FIN back.
 		willScheduleReconnect = true
+		if atomic.CompareAndSwapInt32(&flowConn.tlsWriteBroken, 1, 0) {
+			// nothing, not even EXPECT_RECONNECT, can be sent over connection anymore
+			Logger().Infoln(flowConn.idStr() + " write was interrupted, reconnecting right away")
+			willScheduleReconnect = false
+			err = io.ErrUnexpectedEOF
+		} else if flowConn.canGoDormant() {
+			Logger().Infoln(flowConn.idStr() + " flow is idle, letting connection to decoy lapse")
+			flowConn.abandonOverlapDial()
+		} else if flowConn.overlapping() {
+			return flowConn.overlapReconnect()
+		}
 	}
 
 	// "EOF is the error returned by Read when no more input is available. Functions should
@@ -431,6 +742,9 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 	}
 
 	if willReconnect {
+		if err := flowConn.sleepWhileIdle(); err != nil {
+			return err
+		}
 		if flowConn.flowType != flowReadOnly {
 			// notify writer, if there is a writer
 			select {
@@ -443,7 +757,14 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 			err == io.ErrUnexpectedEOF {
 			Logger().Infoln(flowConn.tdRaw.idStr() + " reconnect: FIN is unexpected")
 		}
+		flowConn.abandonOverlapDial()
+		flowConn.tdRaw.hooks.reconnectStart()
 		err = flowConn.tdRaw.RedialContext(context.Background())
+		flowConn.tdRaw.hooks.reconnectDone(err)
+		if err == nil {
+			flowConn.markReceived() // initial message of the new connection
+			flowConn.publishInfo(true)
+		}
 		if flowConn.flowType != flowReadOnly {
 			// wake up writer engine
 			select {
@@ -452,7 +773,7 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 			}
 		}
 		if err != nil {
-			return errors.New("reconnect: failed to Redial: " + err.Error())
+			return &ReconnectError{Err: err}
 		}
 		flowConn.finSent = false
 		// strip off state transition and push protobuf up for processing
@@ -461,8 +782,8 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 		if err == nil {
 			flowConn.updateReadDeadline()
 			return nil
-		} else if err == errMsgClose {
-			// errMsgClose actually won't show up here
+		} else if err == ErrMsgClose {
+			// ErrMsgClose actually won't show up here
 			Logger().Infoln(flowConn.tdRaw.idStr() + " closing cleanly with MSG_CLOSE")
 			return io.EOF
 		} // else: proceed and exit as a crash
@@ -472,6 +793,7 @@ func (flowConn *TapdanceFlowConn) actOnReadError(err error) error {
 }
 
 // Sets read deadline to {when raw connection was establihsed} + {timeout} - {small random value}
+// With overlapping reconnects, reader wakes up earlier to dial the next connection in advance.
 func (flowConn *TapdanceFlowConn) updateReadDeadline() {
 	amortizationVal := 0.9
 	const minSubtrahend = 50
@@ -479,9 +801,307 @@ func (flowConn *TapdanceFlowConn) updateReadDeadline() {
 	deadline := flowConn.tdRaw.establishedAt.Add(time.Millisecond *
 		time.Duration(int(float64(flowConn.tdRaw.decoySpec.GetTimeout())*amortizationVal)-
 			getRandInt(minSubtrahend, maxSubtrahend)))
+	flowConn.reconnectDeadline = deadline
+	if flowConn.overlapping() && flowConn.overlapResult == nil {
+		deadline = flowConn.tdRaw.establishedAt.Add(time.Duration(overlapPrepareShare *
+			float64(deadline.Sub(flowConn.tdRaw.establishedAt))))
+	}
 	flowConn.tdRaw.tlsConn.SetReadDeadline(deadline)
 }
 
+func (flowConn *TapdanceFlowConn) markActivity() {
+	atomic.StoreInt64(&flowConn.lastActivity, time.Now().UnixNano())
+}
+
+func (flowConn *TapdanceFlowConn) idleFor() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&flowConn.lastActivity)))
+}
+
+// Checks whether flow is idle for long enough to skip upcoming reconnect. If so, marks flow
+// as going dormant: it will still tell station to expect reconnect and wait for FIN, as usual,
+// but won't redial until woken up. Only bidirectional flows go dormant, since split flows
+// have to keep both connections in sync.
+func (flowConn *TapdanceFlowConn) canGoDormant() bool {
+	if flowConn.tdRaw.idleTimeout <= 0 || flowConn.flowType != flowBidirectional ||
+		flowConn.finSent || atomic.LoadInt32(&flowConn.writeClosed) != 0 {
+		return false
+	}
+	// clear stale wake up signal first: Write, that happens after, will signal again,
+	// and Write, that happened before, will show up as activity
+	select {
+	case <-flowConn.wake:
+	default:
+	}
+	if flowConn.idleFor() < flowConn.tdRaw.idleTimeout {
+		return false
+	}
+	flowConn.dormantSince = time.Now()
+	return true
+}
+
+// If flow went dormant, keeps it without connection to decoy, until user writes, or
+// MaxDormant passes. Station keeps the session meanwhile, as it was told to expect reconnect.
+// If station sent data, while previous connection was being closed, flow wakes up right away.
+func (flowConn *TapdanceFlowConn) sleepWhileIdle() error {
+	if flowConn.dormantSince.IsZero() {
+		return nil
+	}
+	dormantSince := flowConn.dormantSince
+	flowConn.dormantSince = time.Time{}
+	if time.Since(dormantSince) > flowConn.idleFor() {
+		Logger().Infoln(flowConn.idStr() + " flow became active, while going dormant")
+		return nil
+	}
+
+	Logger().Infoln(flowConn.idStr() + " flow is dormant until next Write or MaxDormant")
+	var maxDormant <-chan time.Time
+	if flowConn.tdRaw.maxDormant > 0 {
+		timer := time.NewTimer(flowConn.tdRaw.maxDormant - time.Since(dormantSince))
+		defer timer.Stop()
+		maxDormant = timer.C
+	}
+	select {
+	case <-flowConn.wake:
+		Logger().Infoln(flowConn.idStr() + " waking up dormant flow to write")
+	case <-maxDormant:
+		Logger().Infoln(flowConn.idStr() + " waking up dormant flow to check for data")
+	case <-flowConn.closed:
+		return flowConn.closeErr
+	}
+	return nil
+}
+
+func (flowConn *TapdanceFlowConn) markReceived() {
+	atomic.StoreInt64(&flowConn.lastReceived, time.Now().UnixNano())
+}
+
+func (flowConn *TapdanceFlowConn) keepaliveTimeout() time.Duration {
+	if flowConn.tdRaw.keepaliveTimeout > 0 {
+		return flowConn.tdRaw.keepaliveTimeout
+	}
+	return flowConn.tdRaw.keepaliveInterval
+}
+
+// how often writer engine checks liveness: often enough to notice silence timely
+func (flowConn *TapdanceFlowConn) keepaliveCheckPeriod() time.Duration {
+	period := flowConn.tdRaw.keepaliveInterval
+	if timeout := flowConn.keepaliveTimeout(); timeout < period {
+		period = timeout
+	}
+	return period / 4
+}
+
+// Called by writer engine periodically. Sends keepalive, if station was silent for too long,
+// and returns ErrStationUnresponsive, if previous keepalive wasn't answered in time.
+// Any message from station counts as an answer. Unanswered keepalive is fatal only after
+// station answered one, since stations without keepalive support don't answer at all.
+func (flowConn *TapdanceFlowConn) checkLiveness() error {
+	lastReceived := time.Unix(0, atomic.LoadInt64(&flowConn.lastReceived))
+	if flowConn.readBuf.full() {
+		// station may be answering just fine: it's application, that doesn't read
+		flowConn.keepaliveSentAt = time.Time{}
+		return nil
+	}
+	if !flowConn.keepaliveSentAt.IsZero() {
+		if lastReceived.After(flowConn.keepaliveSentAt) {
+			flowConn.keepaliveAnswered = true
+			flowConn.keepaliveSentAt = time.Time{}
+		} else if time.Since(flowConn.keepaliveSentAt) >= flowConn.keepaliveTimeout() {
+			if !flowConn.keepaliveAnswered {
+				Logger().Debugln(flowConn.idStr() + " station didn't answer keepalive, " +
+					"it may not support keepalives")
+				flowConn.keepaliveSentAt = time.Time{}
+				return nil
+			}
+			Logger().Warningln(flowConn.idStr() + " station didn't answer keepalive, sent " +
+				time.Since(flowConn.keepaliveSentAt).String() + " ago")
+			return ErrStationUnresponsive
+		} else {
+			return nil
+		}
+	}
+	if time.Since(lastReceived) < flowConn.tdRaw.keepaliveInterval {
+		return nil
+	}
+	if flowConn.tdRaw.UploadLimit-flowConn.writtenBytesTotal < 6+1024+keepalivePaddingMax {
+		// don't eat into space reserved for transitions: reconnect is coming anyway
+		return nil
+	}
+	n, err := flowConn.tdRaw.writeKeepalive()
+	flowConn.writtenBytesTotal += n
+	if err != nil {
+		// not fatal by itself: unanswered keepalive will tell
+		Logger().Infoln(flowConn.idStr() + " failed to send keepalive: " + err.Error())
+	}
+	flowConn.keepaliveSentAt = time.Now()
+	return nil
+}
+
+// Share of the timeout or upload limit of the connection, after which
+// next connection is dialed in advance, when reconnects overlap
+const overlapPrepareShare = 0.75
+
+type overlapDialResult struct {
+	next *tdRawConn
+	err  error
+}
+
+// Overlapping reconnects are only done by bidirectional flows: upload-only flows already
+// redial without waiting for FIN, and read-only flows don't write.
+func (flowConn *TapdanceFlowConn) overlapping() bool {
+	return flowConn.tdRaw.overlapReconnects && flowConn.flowType == flowBidirectional
+}
+
+// returns connection, that reader engine has to read from
+func (flowConn *TapdanceFlowConn) readConn() *tls.UConn {
+	flowConn.drainMu.Lock()
+	defer flowConn.drainMu.Unlock()
+	if flowConn.drainConn != nil {
+		return flowConn.drainConn
+	}
+	return flowConn.tdRaw.tlsConn
+}
+
+// If previous connection is being drained, err came from it: station closed it or it timed out.
+// Either way, reader moves on to the current connection. Returns whether err was handled.
+func (flowConn *TapdanceFlowConn) finishDraining(err error) bool {
+	flowConn.drainMu.Lock()
+	defer flowConn.drainMu.Unlock()
+	if flowConn.drainConn == nil {
+		return false
+	}
+	Logger().Infoln(flowConn.idStr() + " finished reading previous connection: " + err.Error())
+	flowConn.drainConn.Close()
+	flowConn.drainConn = nil
+	return true
+}
+
+// Make-before-break reconnect. First timeout starts dialing the next connection in background,
+// while current one keeps being used for reads and writes. Once the next connection is
+// established, writer engine is switched over to it at once, and current connection is drained,
+// like in regular reconnect. If current connection has to be abandoned before that, because
+// of the deadline or upload limit, reader waits for the next connection.
+func (flowConn *TapdanceFlowConn) overlapReconnect() error {
+	if flowConn.overlapResult == nil {
+		flowConn.startOverlapDial()
+	}
+	var result overlapDialResult
+	select {
+	case result = <-flowConn.overlapResult:
+	default:
+		urgent := !time.Now().Before(flowConn.reconnectDeadline)
+		select {
+		case <-flowConn.reconnectUrgently:
+			urgent = true
+		default:
+		}
+		if !urgent {
+			flowConn.tdRaw.tlsConn.SetReadDeadline(flowConn.reconnectDeadline)
+			return nil
+		}
+		Logger().Infoln(flowConn.idStr() + " waiting for the next connection")
+		select {
+		case result = <-flowConn.overlapResult:
+		case <-flowConn.closed:
+			return flowConn.closeErr
+		}
+	}
+	flowConn.overlapResult = nil
+	if result.err != nil {
+		return flowConn.closeWithErrorOnce(&ReconnectError{Err: result.err})
+	}
+	return flowConn.switchTo(result.next)
+}
+
+func (flowConn *TapdanceFlowConn) startOverlapDial() {
+	Logger().Infoln(flowConn.idStr() + " dialing next connection in advance")
+	results := make(chan overlapDialResult)
+	flowConn.overlapResult = results
+	next := flowConn.tdRaw.cloneForDial()
+	current := flowConn.tdRaw.tlsConn
+	flowConn.tdRaw.hooks.reconnectStart()
+	go func() {
+		err := next.RedialContext(context.Background())
+		next.hooks.reconnectDone(err)
+		// wake up reader to switch over
+		current.SetReadDeadline(time.Now())
+		select {
+		case results <- overlapDialResult{next: next, err: err}:
+		case <-flowConn.closed:
+			if err == nil {
+				next.tlsConn.Close()
+			}
+		}
+	}()
+	flowConn.tdRaw.tlsConn.SetReadDeadline(flowConn.reconnectDeadline)
+}
+
+// closes the next connection, if it was being dialed in advance
+func (flowConn *TapdanceFlowConn) abandonOverlapDial() {
+	if flowConn.overlapResult == nil {
+		return
+	}
+	go func(results chan overlapDialResult) {
+		select {
+		case r := <-results:
+			if r.err == nil {
+				r.next.tlsConn.Close()
+			}
+		case <-flowConn.closed:
+		}
+	}(flowConn.overlapResult)
+	flowConn.overlapResult = nil
+}
+
+// Switches flow over to the next connection, established in advance.
+func (flowConn *TapdanceFlowConn) switchTo(next *tdRawConn) error {
+	// park writer engine, so it doesn't write to either connection during the switch
+	select {
+	case <-flowConn.closed:
+		return flowConn.closeErr
+	case flowConn.reconnectStarted <- struct{}{}:
+	}
+	_, err := flowConn.tdRaw.writeTransition(pb.C2S_Transition_C2S_EXPECT_RECONNECT)
+	if err != nil {
+		Logger().Infoln(flowConn.idStr() + " failed to send EXPECT_RECONNECT over " +
+			"previous connection: " + err.Error())
+	} else if err = flowConn.tdRaw.closeWrite(); err != nil {
+		Logger().Infoln(flowConn.idStr() + " failed to send FIN over previous connection: " +
+			err.Error())
+	}
+	previous := flowConn.tdRaw.tlsConn
+	previous.SetReadDeadline(time.Now().Add(getRandomDuration(waitForFINDieMin, waitForFINDieMax)))
+
+	flowConn.drainMu.Lock()
+	flowConn.drainConn = previous
+	flowConn.tdRaw.adopt(next)
+	flowConn.drainMu.Unlock()
+	flowConn.markReceived() // initial message of the new connection
+	flowConn.tdRaw.flowId.Inc()
+	flowConn.tdRaw.failedDecoys = append(flowConn.tdRaw.failedDecoys, next.failedDecoys...)
+	flowConn.publishInfo(true)
+	select {
+	case <-flowConn.reconnectUrgently:
+	default:
+	}
+
+	// wake up writer engine
+	select {
+	case <-flowConn.closed:
+		return flowConn.closeErr
+	case flowConn.reconnectSuccess <- true:
+	}
+	Logger().Infoln(flowConn.idStr() + " switched over to connection, established in advance")
+
+	// strip off state transition and push protobuf up for processing
+	flowConn.tdRaw.initialMsg.StateTransition = nil
+	if err = flowConn.processProto(flowConn.tdRaw.initialMsg); err != nil {
+		return flowConn.closeWithErrorOnce(err)
+	}
+	flowConn.updateReadDeadline()
+	return nil
+}
+
 func (flowConn *TapdanceFlowConn) acquireUpload() error {
 	_, err := flowConn.tdRaw.writeTransition(pb.C2S_Transition_C2S_ACQUIRE_UPLOAD)
 	if err != nil {
@@ -502,14 +1122,21 @@ func (flowConn *TapdanceFlowConn) yieldUpload() error {
 	return err
 }
 
-// TODO: implement on station, currently unused
-// wait for flowConn to confirm that flow was noticed
-func (flowConn *TapdanceFlowConn) waitForYieldConfirmation() error {
+// How long split flow waits for station to pair the upload flow with the read-only flow.
+// Variable, so that tests could shorten it.
+var yieldConfirmationTimeout = 20 * time.Second
+
+// waits for station to confirm, via read-only flowConn, that it paired the upload flow, which
+// acquired upload, with flowConn, which yielded it
+func (flowConn *TapdanceFlowConn) waitForYieldConfirmation(ctx context.Context) error {
 	// camouflage issue
-	timeout := time.After(20 * time.Second)
+	timeout := time.NewTimer(yieldConfirmationTimeout)
+	defer timeout.Stop()
 	select {
-	case <-timeout:
+	case <-timeout.C:
 		return errors.New("yield confirmation timeout")
+	case <-ctx.Done():
+		return errors.New("yield confirmation: " + ctx.Err().Error())
 	case <-flowConn.yieldConfirmed:
 		Logger().Infoln(flowConn.idStr() +
 			" Successfully received yield confirmation from reader flow!")
@@ -525,19 +1152,85 @@ func (flowConn *TapdanceFlowConn) closeWithErrorOnce(err error) error {
 		// safeguard, shouldn't happen
 		err = errors.New("closed with nil error!")
 	}
+	closedNow := false
 	flowConn.closeOnce.Do(func() {
-		flowConn.closeErr = errors.New(flowConn.idStr() + " " + err.Error())
-		flowConn.bsbuf.Unblock()
+		closedNow = true
+		flowConn.closeErr = fmt.Errorf("%s %w", flowConn.idStr(), err)
+		if errors.Is(err, ErrStationUnresponsive) {
+			// unlike graceful close, this isn't the end of data: let Read know
+			flowConn.readBuf.UnblockWithError(flowConn.closeErr)
+		} else {
+			flowConn.readBuf.Unblock()
+		}
 		close(flowConn.closed)
 		flowConn.tdRaw.Close()
+		flowConn.drainMu.Lock()
+		if flowConn.drainConn != nil {
+			flowConn.drainConn.Close()
+		}
+		flowConn.drainMu.Unlock()
 	})
+	if closedNow {
+		// outside of closeOnce, so that callback may call Close
+		flowConn.tdRaw.hooks.closed(flowConn.closeErr)
+	}
 	return flowConn.closeErr
 }
 
+// How long Close waits for C2S_SESSION_CLOSE to be sent, e.g. while reconnecting
+var sessionCloseTimeout = 5 * time.Second
+
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
+// Unless flow is read-only, station is told to close the session first, so that connection
+// to covert destination is closed gracefully, instead of being reset. Writer may be busy,
+// e.g. reconnecting, so Close may block for up to sessionCloseTimeout, meanwhile Reads
+// return io.EOF and Writes fail with ErrWriteClosed. Flow is torn down, once Close returns.
+// Read-only flows can't write, and leave that to their paired writer flow.
+//
+// There is no CloseWrite: station has no transition, that would pass FIN on to covert
+// destination, and keep the session open for its response.
 func (flowConn *TapdanceFlowConn) Close() error {
-	return flowConn.closeWithErrorOnce(errors.New("closed by application layer"))
+	if !isClosedChan(flowConn.closed) && flowConn.sessionCloseChan != nil &&
+		atomic.CompareAndSwapInt32(&flowConn.writeClosed, 0, 1) {
+		flowConn.CloseRead()
+		if err := flowConn.sendSessionClose(sessionCloseTimeout); err != nil {
+			Logger().Infoln(flowConn.idStr() + " failed to send SESSION_CLOSE: " + err.Error())
+		}
+	}
+	return flowConn.closeWithErrorOnce(ErrClosedByApplication)
+}
+
+// CloseRead shuts down the reading side of the connection: data, that was received, but not
+// read yet, and data, that arrives later, is discarded, and Read returns io.EOF.
+// As with TCP, remote side isn't notified.
+func (flowConn *TapdanceFlowConn) CloseRead() error {
+	atomic.StoreInt32(&flowConn.readClosed, 1)
+	flowConn.readBuf.Close()
+	return nil
+}
+
+// asks writer engine to send C2S_SESSION_CLOSE in between writes, and waits for result
+func (flowConn *TapdanceFlowConn) sendSessionClose(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	result := make(chan error, 1)
+	select {
+	case flowConn.sessionCloseChan <- result:
+	case <-flowConn.closed:
+		return flowConn.closeErr
+	case <-timer.C:
+		return timeoutError{}
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-flowConn.closed:
+		return flowConn.closeErr
+	case <-timer.C:
+		// e.g. stuck in TLS write of queued data
+		return timeoutError{}
+	}
 }
 
 func (flowConn *TapdanceFlowConn) idStr() string {
@@ -546,7 +1239,7 @@ func (flowConn *TapdanceFlowConn) idStr() string {
 
 func (flowConn *TapdanceFlowConn) processProto(msg pb.StationToClient) error {
 	handleConfigInfo := func(conf *pb.ClientConf) {
-		currGen := Assets().GetGeneration()
+		currGen := flowConn.tdRaw.assets.GetGeneration()
 		if conf.GetGeneration() < currGen {
 			Logger().Infoln(flowConn.idStr()+" not appliying new config due"+
 				" to lower generation: ", conf.GetGeneration(), " "+
@@ -558,31 +1251,37 @@ func (flowConn *TapdanceFlowConn) processProto(msg pb.StationToClient) error {
 			return
 		}
 
-		_err := Assets().SetClientConf(conf)
+		_err := flowConn.tdRaw.assets.SetClientConf(conf)
 		if _err != nil {
 			Logger().Warningln(flowConn.idStr() +
 				" could not persistently set ClientConf: " + _err.Error())
 		}
+		flowConn.tdRaw.hooks.clientConfUpdated(conf.GetGeneration())
 	}
 	Logger().Debugln(flowConn.idStr() + " processing incoming protobuf: " + msg.String())
 	// handle ConfigInfo
 	if confInfo := msg.ConfigInfo; confInfo != nil {
 		handleConfigInfo(confInfo)
 		// TODO: if we ever get a ``safe'' decoy rotation - code below has to be rewritten
-		if !Assets().IsDecoyInList(flowConn.tdRaw.decoySpec) {
+		if !flowConn.tdRaw.assets.IsDecoyInList(flowConn.tdRaw.decoySpec) {
 			Logger().Warningln(flowConn.idStr() + " current decoy is no " +
 				"longer in the list, changing it! Read flow probably will break!")
 			// if current decoy is no longer in the list
-			flowConn.tdRaw.decoySpec = Assets().GetDecoy()
+			flowConn.tdRaw.decoySpec = flowConn.tdRaw.assets.GetDecoyWithSelector(flowConn.tdRaw.decoySelector)
 		}
-		if !Assets().IsDecoyInList(flowConn.tdRaw.decoySpec) {
+		if !flowConn.tdRaw.assets.IsDecoyInList(flowConn.tdRaw.decoySpec) {
 			Logger().Warningln(flowConn.idStr() + " current decoy is no " +
 				"longer in the list, changing it! Write flow probably will break!")
 			// if current decoy is no longer in the list
-			flowConn.tdRaw.decoySpec = Assets().GetDecoy()
+			flowConn.tdRaw.decoySpec = flowConn.tdRaw.assets.GetDecoyWithSelector(flowConn.tdRaw.decoySelector)
 		}
 	}
 
+	if backoff := msg.GetTmpBackoff(); backoff > 0 {
+		Logger().Infof("%s station requested backoff for %d seconds", flowConn.idStr(), backoff)
+		flowConn.tdRaw.assets.setBackoff(time.Duration(backoff) * time.Second)
+	}
+
 	// note that flowConn don't see first-message transitions, such as INIT or RECONNECT
 	stateTransition := msg.GetStateTransition()
 	switch stateTransition {
@@ -590,10 +1289,32 @@ func (flowConn *TapdanceFlowConn) processProto(msg pb.StationToClient) error {
 	// carry on
 	case pb.S2C_Transition_S2C_SESSION_CLOSE:
 		Logger().Infof(flowConn.idStr() + " received MSG_CLOSE")
-		return errMsgClose
+		return ErrMsgClose
+	case pb.S2C_Transition_S2C_CONFIRM_YIELD:
+		if flowConn.yieldConfirmed == nil {
+			err := errors.New("Unexpected StateTransition " +
+				"in flow, that didn't yield upload:" + stateTransition.String())
+			Logger().Errorln(flowConn.idStr() + " " + err.Error())
+			flowConn.closeWithErrorOnce(err)
+			return err
+		}
+		// station may repeat confirmation, e.g. after reconnect
+		if !isClosedChan(flowConn.yieldConfirmed) {
+			close(flowConn.yieldConfirmed)
+		}
 	case pb.S2C_Transition_S2C_ERROR:
-		err := errors.New("message from station:" +
-			msg.GetErrReason().String())
+		if msg.GetErrReason() == pb.ErrorReasonS2C_DECOY_OVERLOAD {
+			// not fatal: reconnect via another decoy
+			Logger().Warningln(flowConn.idStr() + " decoy " +
+				decoyKey(&flowConn.tdRaw.decoySpec) + " is overloaded, reconnecting")
+			flowConn.tdRaw.assets.recordDecoyEvent(&flowConn.tdRaw.decoySpec, decoyEventOverloaded)
+			if !flowConn.tdRaw.pinDecoySpec {
+				flowConn.tdRaw.decoySpec = flowConn.tdRaw.pickAnotherDecoy()
+			}
+			flowConn.schedReconnectNow()
+			return nil
+		}
+		err := &StationError{Reason: msg.GetErrReason()}
 		Logger().Errorln(flowConn.idStr() + " " + err.Error())
 		flowConn.closeWithErrorOnce(err)
 		return err
@@ -617,17 +1338,15 @@ func (flowConn *TapdanceFlowConn) LocalAddr() net.Addr {
 }
 
 // RemoteAddr returns the address of current decoy.
-// Not goroutine-safe, mostly here to satisfy net.Conn
 func (flowConn *TapdanceFlowConn) RemoteAddr() net.Addr {
-	return flowConn.tdRaw.tlsConn.RemoteAddr()
+	flowConn.info.mu.Lock()
+	defer flowConn.info.mu.Unlock()
+	return flowConn.info.remoteAddr
 }
 
-// SetDeadline is supposed to set the read and write deadlines
-// associated with the connection. It is equivalent to calling
-// both SetReadDeadline and SetWriteDeadline.
-//
-// TODO: In reality, SetDeadline doesn't do that yet, but
-// existence of this function is mandatory to implement net.Conn
+// SetDeadline sets the read and write deadlines associated
+// with the connection. It is equivalent to calling both
+// SetReadDeadline and SetWriteDeadline.
 //
 // A deadline is an absolute time after which I/O operations
 // fail with a timeout (see type Error) instead of
@@ -640,13 +1359,16 @@ func (flowConn *TapdanceFlowConn) RemoteAddr() net.Addr {
 // A zero value for t means I/O operations will not time out.
 //
 func (flowConn *TapdanceFlowConn) SetDeadline(t time.Time) error {
-	return errNotImplemented
+	flowConn.readDeadline.set(t)
+	flowConn.writeDeadline.set(t)
+	return nil
 }
 
 // SetReadDeadline sets the deadline for future Read calls.
 // A zero value for t means Read will not time out.
 func (flowConn *TapdanceFlowConn) SetReadDeadline(t time.Time) error {
-	return errNotImplemented
+	flowConn.readDeadline.set(t)
+	return nil
 }
 
 // SetWriteDeadline sets the deadline for future Write calls.
@@ -654,5 +1376,6 @@ func (flowConn *TapdanceFlowConn) SetReadDeadline(t time.Time) error {
 // some of the data was successfully written.
 // A zero value for t means Write will not time out.
 func (flowConn *TapdanceFlowConn) SetWriteDeadline(t time.Time) error {
-	return errNotImplemented
+	flowConn.writeDeadline.set(t)
+	return nil
 }
@@ -0,0 +1,813 @@
+package tapdance
+
+import (
+	"bytes"
+	"context"
+	ctls "crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	tls "github.com/refraction-networking/utls"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// testStation is a stand-in for TapDance station behind testDecoy: it picks up every
+// connection, confirms initial and reconnect requests, and echoes raw data back.
+// Echoing destination closes, once client closes the session. Keepalives are answered.
+// Upload flows of SplitFlows are paired with the flow, that yielded upload, which gets the
+// echo of uploaded data.
+type testStation struct {
+	*testDecoy
+
+	mu           sync.Mutex
+	conns        int      // connections picked up so far
+	events       []string // what happened, in order
+	unresponsive bool     // ignore everything, as if path to the station died
+	yielded      net.Conn // connection, that yielded upload
+	noYieldConf  bool     // don't confirm pairing of upload flows
+	noKeepalives bool     // don't answer keepalives, like stations without keepalive support
+}
+
+func startTestStation(t *testing.T) *testStation {
+	station := &testStation{}
+	station.testDecoy = startTestDecoy(t, ctls.VersionTLS12, station.serve, 0)
+	return station
+}
+
+func (station *testStation) logEvent(event string) {
+	station.mu.Lock()
+	station.events = append(station.events, event)
+	station.mu.Unlock()
+}
+
+func (station *testStation) serve(conn net.Conn) {
+	// request with the tag always fits into a single TLS record
+	request := make([]byte, 4096)
+	n, err := conn.Read(request)
+	if err != nil {
+		return
+	}
+	uploadOnly := bytes.HasPrefix(request[:n], []byte("POST"))
+	station.mu.Lock()
+	connID := strconv.Itoa(station.conns)
+	transition := pb.S2C_Transition_S2C_CONFIRM_RECONNECT
+	if station.conns == 0 {
+		transition = pb.S2C_Transition_S2C_SESSION_INIT
+	}
+	station.conns++
+	station.mu.Unlock()
+	station.logEvent("picked up " + connID)
+
+	if !uploadOnly {
+		// client doesn't wait for response to upload-only request
+		initialMsg, _ := proto.Marshal(&pb.StationToClient{StateTransition: &transition})
+		conn.Write(getMsgWithHeader(msgProtobuf, initialMsg))
+	}
+	downstream := conn
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		typeLen := int(int16(binary.BigEndian.Uint16(header)))
+		msg := make([]byte, maxInt(typeLen, -typeLen))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		station.mu.Lock()
+		unresponsive := station.unresponsive
+		station.mu.Unlock()
+		if unresponsive {
+			continue
+		}
+		if typeLen < 0 {
+			downstream.Write(getMsgWithHeader(msgRawData, msg))
+			continue
+		}
+		var c2s pb.ClientToStation
+		if err := proto.Unmarshal(msg, &c2s); err == nil {
+			station.logEvent(c2s.GetStateTransition().String() + " " + connID)
+			station.mu.Lock()
+			noKeepalives := station.noKeepalives
+			station.mu.Unlock()
+			if c2s.StateTransition != nil && !noKeepalives &&
+				c2s.GetStateTransition() == pb.C2S_Transition_C2S_NO_CHANGE {
+				answer, _ := proto.Marshal(&pb.StationToClient{Padding: c2s.Padding})
+				conn.Write(getMsgWithHeader(msgProtobuf, answer))
+			}
+			if c2s.GetStateTransition() == pb.C2S_Transition_C2S_YIELD_UPLOAD {
+				station.mu.Lock()
+				station.yielded = conn
+				station.mu.Unlock()
+			}
+			if c2s.GetStateTransition() == pb.C2S_Transition_C2S_ACQUIRE_UPLOAD {
+				station.mu.Lock()
+				yielded, noYieldConf := station.yielded, station.noYieldConf
+				station.mu.Unlock()
+				if yielded != nil {
+					downstream = yielded
+					if !noYieldConf {
+						station.logEvent("confirmed yield " + connID)
+						confirmTransition := pb.S2C_Transition_S2C_CONFIRM_YIELD
+						confirmMsg, _ := proto.Marshal(&pb.StationToClient{
+							StateTransition: &confirmTransition})
+						yielded.Write(getMsgWithHeader(msgProtobuf, confirmMsg))
+					}
+				}
+			}
+			if c2s.GetStateTransition() == pb.C2S_Transition_C2S_SESSION_CLOSE {
+				closeTransition := pb.S2C_Transition_S2C_SESSION_CLOSE
+				closeMsg, _ := proto.Marshal(&pb.StationToClient{StateTransition: &closeTransition})
+				conn.Write(getMsgWithHeader(msgProtobuf, closeMsg))
+			}
+		}
+	}
+}
+
+func TestFlow_DecoyOverload(t *testing.T) {
+	a := makeAssetsFromConf(&pb.ClientConf{
+		DecoyList: &pb.DecoyList{TlsDecoys: testSelectorDecoys},
+	}, nil)
+	tdRaw := makeTdRaw(tagHttpGetIncomplete, a)
+	tdRaw.decoySpec = *testSelectorDecoys[0]
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	tdRaw.tlsConn = tls.UClient(clientConn, &tls.Config{}, tls.HelloChrome_62)
+
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	transition := pb.S2C_Transition_S2C_ERROR
+	reason := pb.ErrorReasonS2C_DECOY_OVERLOAD
+	backoff := uint32(3)
+	err = flow.processProto(pb.StationToClient{StateTransition: &transition,
+		ErrReason: &reason, TmpBackoff: &backoff})
+	if err != nil {
+		t.Fatalf("Overloaded decoy killed the flow: %v", err)
+	}
+	if flow.tdRaw.decoySpec.GetHostname() == testSelectorDecoys[0].GetHostname() {
+		t.Fatal("Overloaded decoy was not replaced")
+	}
+	if !a.health.isBlacklisted(testSelectorDecoys[0]) {
+		t.Fatal("Overloaded decoy was not blacklisted")
+	}
+	if a.backoffRemaining() <= 0 {
+		t.Fatal("Station backoff was ignored")
+	}
+}
+
+func TestFlow_ReadDeadline(t *testing.T) {
+	flow, err := makeTdFlow(flowBidirectional,
+		makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(nil, nil)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	buf := make([]byte, 16)
+	flow.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = flow.Read(buf)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+
+	// extended deadline has to unblock reads again
+	flow.SetReadDeadline(time.Time{})
+	flow.readBuf.Write([]byte("data"), nil)
+	n, err := flow.Read(buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Fatalf("Expected to read data, got %q, %v", buf[:n], err)
+	}
+}
+
+func TestFlow_WriteDeadline(t *testing.T) {
+	flow, err := makeTdFlow(flowBidirectional,
+		makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(nil, nil)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+	// writer engine is not running, so Write blocks on handoff
+	flow.writeSliceChan = make(chan []byte)
+	flow.writeResultChan = make(chan ioOpResult)
+
+	flow.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, err = flow.Write([]byte("data"))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Write took too long to time out")
+	}
+
+	flow.SetDeadline(time.Now().Add(-time.Second))
+	if _, err = flow.Write([]byte("data")); err == nil {
+		t.Fatal("Write succeeded with deadline in the past")
+	}
+}
+
+func TestFlow_WriteDeadlineFullWindow(t *testing.T) {
+	// station stops reading the first connection, so that TCP window fills up
+	var mu sync.Mutex
+	conns := 0
+	stuck := make(chan struct{})
+	station := startTestDecoy(t, ctls.VersionTLS12, func(conn net.Conn) {
+		if _, err := conn.Read(make([]byte, 4096)); err != nil {
+			return
+		}
+		mu.Lock()
+		transition := pb.S2C_Transition_S2C_CONFIRM_RECONNECT
+		if conns == 0 {
+			transition = pb.S2C_Transition_S2C_SESSION_INIT
+		}
+		conns++
+		mu.Unlock()
+		initialMsg, _ := proto.Marshal(&pb.StationToClient{StateTransition: &transition})
+		conn.Write(getMsgWithHeader(msgProtobuf, initialMsg))
+		if transition == pb.S2C_Transition_S2C_SESSION_INIT {
+			<-stuck
+			return
+		}
+		discardConn(conn)
+	}, 0)
+	defer station.stop()
+	defer close(stuck)
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(1 << 30)
+	// reconnects, rather than connections: under load, reconnect may take a few attempts
+	var reconnects []error
+	tdRaw.hooks = &Hooks{
+		OnReconnectDone: func(err error) {
+			mu.Lock()
+			reconnects = append(reconnects, err)
+			mu.Unlock()
+		},
+	}
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	data := make([]byte, 64<<20) // way more, than socket buffers can take
+	flow.SetWriteDeadline(time.Now().Add(300 * time.Millisecond))
+	start := time.Now()
+	n, err := flow.Write(data)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("Expected timeout, got %d, %v", n, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Write took %v to time out", time.Since(start))
+	}
+
+	// flow moves on to a new connection
+	flow.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err = flow.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reconnects) != 1 || reconnects[0] != nil {
+		t.Fatalf("Expected flow to reconnect once, got %v", reconnects)
+	}
+}
+
+func TestFlow_CloseWhileWriting(t *testing.T) {
+	// station stops reading after SESSION_INIT, so that writer gets stuck in TLS write
+	stuck := make(chan struct{})
+	station := startTestDecoy(t, ctls.VersionTLS12, func(conn net.Conn) {
+		if _, err := conn.Read(make([]byte, 4096)); err != nil {
+			return
+		}
+		transition := pb.S2C_Transition_S2C_SESSION_INIT
+		initialMsg, _ := proto.Marshal(&pb.StationToClient{StateTransition: &transition})
+		conn.Write(getMsgWithHeader(msgProtobuf, initialMsg))
+		<-stuck
+	}, 0)
+	defer station.stop()
+	defer close(stuck)
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(1 << 30)
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := flow.Write(make([]byte, 64<<20))
+		writeErr <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// writer can't send SESSION_CLOSE, so Close gives up on it in sessionCloseTimeout
+	defer func(timeout time.Duration) { sessionCloseTimeout = timeout }(sessionCloseTimeout)
+	sessionCloseTimeout = 500 * time.Millisecond
+	start := time.Now()
+	if err = flow.Close(); !errors.Is(err, ErrClosedByApplication) {
+		t.Fatalf("Unexpected error on Close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*sessionCloseTimeout {
+		t.Fatalf("Close took %v", elapsed)
+	}
+	// flow is torn down, once Close returns
+	select {
+	case err = <-writeErr:
+		if !errors.Is(err, ErrClosedByApplication) {
+			t.Fatalf("Expected ErrClosedByApplication, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write is still blocked after Close")
+	}
+}
+
+func TestFlow_OverlapReconnect(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000) // reconnect every ~15KB
+	tdRaw.overlapReconnects = true
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	sent := make([]byte, 64*1024)
+	for i := range sent {
+		sent[i] = byte(i)
+	}
+	go func() {
+		for i := 0; i < len(sent); i += 1000 {
+			if _, err := flow.Write(sent[i:minInt(i+1000, len(sent))]); err != nil {
+				return
+			}
+		}
+	}()
+	received := make([]byte, len(sent))
+	flow.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err = io.ReadFull(flow, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("Data was corrupted across reconnects")
+	}
+
+	station.mu.Lock()
+	defer station.mu.Unlock()
+	if station.conns < 3 {
+		t.Fatalf("Expected at least 2 reconnects, got %d", station.conns-1)
+	}
+	// every next connection has to be picked up, before the previous one is abandoned
+	for i := 1; i < station.conns; i++ {
+		for _, event := range station.events {
+			if event == "picked up "+strconv.Itoa(i) {
+				break
+			}
+			if event == pb.C2S_Transition_C2S_EXPECT_RECONNECT.String()+" "+strconv.Itoa(i-1) {
+				t.Fatalf("Connection %d was abandoned before the next one was ready: %v",
+					i-1, station.events)
+			}
+		}
+	}
+}
+
+func TestFlow_IdleReconnect(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.idleTimeout = 10 * time.Millisecond
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	// idle flow has to tell station to keep the session, and not reconnect
+	time.Sleep(50 * time.Millisecond)
+	flow.schedReconnectNow()
+	expectReconnect := pb.C2S_Transition_C2S_EXPECT_RECONNECT.String() + " 0"
+	for i := 0; ; i++ {
+		station.mu.Lock()
+		events := strings.Join(station.events, ", ")
+		station.mu.Unlock()
+		if strings.Contains(events, expectReconnect) {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Station wasn't told to expect reconnect: %s", events)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	station.mu.Lock()
+	conns := station.conns
+	station.mu.Unlock()
+	if conns != 1 {
+		t.Fatalf("Idle flow reconnected %d times", conns-1)
+	}
+
+	// next Write wakes flow up
+	if _, err = flow.Write([]byte("wake up")); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len("wake up"))
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = io.ReadFull(flow, received); err != nil {
+		t.Fatal(err)
+	}
+	if string(received) != "wake up" {
+		t.Fatalf("Received %q after waking up", received)
+	}
+	station.mu.Lock()
+	defer station.mu.Unlock()
+	if station.conns != 2 {
+		t.Fatalf("Expected exactly 1 reconnect, got %d", station.conns-1)
+	}
+}
+
+func TestFlow_MaxDormant(t *testing.T) {
+	if tdRaw := (&Dialer{}).makeTdRaw(tagHttpGetIncomplete); tdRaw.maxDormant != defaultMaxDormant {
+		t.Fatalf("Expected default MaxDormant %v, got %v", defaultMaxDormant, tdRaw.maxDormant)
+	}
+	if tdRaw := (&Dialer{MaxDormant: -1}).makeTdRaw(tagHttpGetIncomplete); tdRaw.maxDormant != 0 {
+		t.Fatalf("Negative MaxDormant has to disable the limit, got %v", tdRaw.maxDormant)
+	}
+
+	station := startTestStation(t)
+	defer station.stop()
+
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.idleTimeout = 10 * time.Millisecond
+	tdRaw.maxDormant = 300 * time.Millisecond
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	// dormant flow reconnects by itself, once MaxDormant passes
+	time.Sleep(50 * time.Millisecond)
+	flow.schedReconnectNow()
+	for i := 0; ; i++ {
+		station.mu.Lock()
+		conns := station.conns
+		station.mu.Unlock()
+		if conns == 2 {
+			break
+		}
+		if i == 500 {
+			t.Fatal("Dormant flow didn't wake up after MaxDormant")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlow_Close(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.CloseRead(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = flow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected EOF after CloseRead, got %v", err)
+	}
+	if _, err = flow.Write([]byte("echo is discarded")); err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.Close(); !errors.Is(err, ErrClosedByApplication) {
+		t.Fatalf("Unexpected error on Close: %v", err)
+	}
+	// Close returns, once flow is torn down
+	if !isClosedChan(flow.closed) {
+		t.Fatal("Flow is still open after Close")
+	}
+	if _, err = flow.Write([]byte("more")); !errors.Is(err, ErrClosedByApplication) {
+		t.Fatalf("Expected ErrClosedByApplication, got %v", err)
+	}
+	sessionCloses := func() int {
+		station.mu.Lock()
+		defer station.mu.Unlock()
+		count := 0
+		for _, event := range station.events {
+			if event == pb.C2S_Transition_C2S_SESSION_CLOSE.String()+" 0" {
+				count++
+			}
+		}
+		return count
+	}
+	for i := 0; sessionCloses() != 1; i++ {
+		if i == 100 {
+			t.Fatal("Station wasn't told to close the session on Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlow_Keepalive(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.keepaliveInterval = 50 * time.Millisecond
+	tdRaw.keepaliveTimeout = 100 * time.Millisecond
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	// answered keepalives keep flow alive, while no data flows
+	time.Sleep(500 * time.Millisecond)
+	if isClosedChan(flow.closed) {
+		t.Fatalf("Flow was closed: %v", flow.closeErr)
+	}
+	station.mu.Lock()
+	keepalives := strings.Count(strings.Join(station.events, ","),
+		pb.C2S_Transition_C2S_NO_CHANGE.String())
+	station.unresponsive = true
+	station.mu.Unlock()
+	if keepalives < 3 {
+		t.Fatalf("Expected keepalives to be sent, got %d", keepalives)
+	}
+
+	// dead path is noticed by blocked Read within timeout
+	start := time.Now()
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = flow.Read(make([]byte, 1)); !errors.Is(err, ErrStationUnresponsive) {
+		t.Fatalf("Expected ErrStationUnresponsive, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Liveness failure took %v to notice", time.Since(start))
+	}
+
+	// station, that never answers keepalives, isn't considered dead
+	station2 := startTestStation(t)
+	defer station2.stop()
+	station2.noKeepalives = true
+	tdRaw = station2.makeTdRaw(makeAssetsFromConf(nil, station2.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.keepaliveInterval = 50 * time.Millisecond
+	tdRaw.keepaliveTimeout = 100 * time.Millisecond
+	flow2, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow2.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow2.Close()
+	time.Sleep(500 * time.Millisecond)
+	if isClosedChan(flow2.closed) {
+		t.Fatalf("Flow was closed: %v", flow2.closeErr)
+	}
+	station2.mu.Lock()
+	keepalives = strings.Count(strings.Join(station2.events, ","),
+		pb.C2S_Transition_C2S_NO_CHANGE.String())
+	station2.mu.Unlock()
+	if keepalives < 2 {
+		t.Fatalf("Expected keepalives to be sent, got %d", keepalives)
+	}
+}
+
+// small asynchronous writes are coalesced, and survive reconnects
+func TestFlow_WriteQueue(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.writeQueueSize = 4096
+	var connsMu sync.Mutex
+	var conns []*recordingConn
+	dial := tdRaw.TcpDialer
+	tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		recConn := &recordingConn{TCPConn: conn.(*net.TCPConn)}
+		connsMu.Lock()
+		conns = append(conns, recConn)
+		connsMu.Unlock()
+		return recConn, nil
+	}
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	const writes = 2000
+	var sent []byte
+	for i := 0; i < writes; i++ {
+		chunk := []byte(fmt.Sprintf("%09d ", i))
+		sent = append(sent, chunk...)
+		if n, err := flow.Write(chunk); n != len(chunk) || err != nil {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+	}
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, len(sent))
+	if _, err = io.ReadFull(flow, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatalf("Sent %d bytes, received %d bytes, that differ", len(sent), len(received))
+	}
+
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	if len(conns) < 2 {
+		t.Fatal("Upload limit didn't cause reconnect")
+	}
+	records := 0
+	for _, conn := range conns {
+		conn.Lock()
+		written := conn.written.Bytes()
+		for offset := 0; offset+5 <= len(written); {
+			if written[offset] == 23 { // application_data
+				records++
+			}
+			offset += 5 + int(binary.BigEndian.Uint16(written[offset+3:offset+5]))
+		}
+		conn.Unlock()
+	}
+	if records > writes/4 {
+		t.Fatalf("%d writes weren't coalesced: sent %d records", writes, records)
+	}
+}
+
+func TestWriteQueue(t *testing.T) {
+	q := makeWriteQueue(10)
+	cancel := make(chan struct{})
+	close(cancel)
+	if n, err := q.put([]byte("0123456789abc"), cancel, nil); n != 10 || err != (timeoutError{}) {
+		t.Fatalf("Expected 10 bytes to be queued until timeout, got %d, %v", n, err)
+	}
+	data := q.take()
+	if string(data) != "0123456789" {
+		t.Fatalf("Took %q", data)
+	}
+	if n, err := q.put([]byte("x"), cancel, nil); n != 0 || err != (timeoutError{}) {
+		t.Fatalf("Data in flight wasn't counted: got %d, %v", n, err)
+	}
+	sendErr := errors.New("send failed")
+	q.done(data, sendErr)
+	if _, err := q.put([]byte("x"), nil, nil); err != sendErr {
+		t.Fatalf("Expected error of queued data, got %v", err)
+	}
+}
+
+// starts a station, that confirms every connection and then hands it over to run
+func startBenchStation(b *testing.B, run func(conn net.Conn)) *testDecoy {
+	var mu sync.Mutex
+	conns := 0
+	return startTestDecoy(b, ctls.VersionTLS12, func(conn net.Conn) {
+		if _, err := conn.Read(make([]byte, 4096)); err != nil {
+			return
+		}
+		mu.Lock()
+		transition := pb.S2C_Transition_S2C_CONFIRM_RECONNECT
+		if conns == 0 {
+			transition = pb.S2C_Transition_S2C_SESSION_INIT
+		}
+		conns++
+		mu.Unlock()
+		initialMsg, _ := proto.Marshal(&pb.StationToClient{StateTransition: &transition})
+		conn.Write(getMsgWithHeader(msgProtobuf, initialMsg))
+		run(conn)
+	}, 0)
+}
+
+func dialBenchFlow(b *testing.B, station *testDecoy) *TapdanceFlowConn {
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(600000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(1<<32 - 1)
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	return flow
+}
+
+// allocations per MB, written to the flow in 16KB chunks
+func BenchmarkFlow_Write(b *testing.B) {
+	station := startBenchStation(b, func(conn net.Conn) {
+		io.Copy(ioutil.Discard, conn)
+	})
+	defer station.stop()
+	flow := dialBenchFlow(b, station)
+	defer flow.Close()
+
+	chunk := make([]byte, 16*1024)
+	b.SetBytes(1 << 20)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for sent := 0; sent < 1<<20; sent += len(chunk) {
+			if _, err := flow.Write(chunk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// allocations per MB, read from the flow, that station fills with 16KB messages
+func BenchmarkFlow_Read(b *testing.B) {
+	station := startBenchStation(b, func(conn net.Conn) {
+		msgs := bytes.Repeat(getMsgWithHeader(msgRawData, make([]byte, 16*1024)), 16)
+		for {
+			if _, err := conn.Write(msgs); err != nil {
+				return
+			}
+		}
+	})
+	defer station.stop()
+	flow := dialBenchFlow(b, station)
+	defer flow.Close()
+
+	buf := make([]byte, 32*1024)
+	b.SetBytes(1 << 20)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for received := 0; received < 1<<20; {
+			n, err := flow.Read(buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			received += n
+		}
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net"
 	"strconv"
@@ -32,6 +35,40 @@ type tdRawConn struct {
 
 	TcpDialer func(context.Context, string, string) (net.Conn, error)
 
+	assets        *assets       // source of decoys, ClientConf and station pubkey
+	decoySelector DecoySelector // picks decoys, if nil: uniformly random
+	raceDecoys    int           // how many decoys to dial concurrently during initial dial
+	raceStagger   time.Duration // delay between starting concurrent dials
+
+	overlapReconnects       bool // dial next connection before the current one is closed
+	rotateDecoysOnReconnect bool // switch decoys, when reconnect through current one fails
+
+	idleTimeout time.Duration // if flow is idle for that long, it doesn't reconnect until Write
+	maxDormant  time.Duration // how long idle flow may stay without connection, 0 - indefinitely
+
+	keepaliveInterval time.Duration // send keepalive, if station is silent for that long
+	keepaliveTimeout  time.Duration // how long to wait for station to answer keepalive
+
+	shaping *ShapingProfile // disguises sizes and timing of sent data, nil disables shaping
+
+	uploadRate      int // bytes per second, limit of the flow
+	downloadRate    int
+	uploadLimiter   *RateLimiter // shared by flows of the Dialer
+	downloadLimiter *RateLimiter
+
+	receiveBufferSize int // max bytes, received, but not read by application yet, 0 - unbounded
+	writeQueueSize    int // max bytes, queued by asynchronous Writes, 0 - Writes are synchronous
+
+	dialRetry      *RetryPolicy // if nil, DefaultDialRetryPolicy() is used
+	reconnectRetry *RetryPolicy // if nil, DefaultReconnectRetryPolicy() is used
+
+	hooks *Hooks // lifecycle callbacks of the application, may be nil
+
+	clientHelloIDs      []tls.ClientHelloID // parrots to rotate across decoys
+	fallbackClientHello bool                // use defaultClientHelloID, e.g. after cipher mismatch
+	allowTLS13          bool                // station supports TLS 1.3 layout of the tag
+	trafficSecrets      *trafficSecretsLog  // TLS 1.3 secrets of current connection to decoy
+
 	decoySpec     pb.TLSDecoySpec
 	pinDecoySpec  bool // don't ever change decoy (still changeable from outside)
 	initialMsg    pb.StationToClient
@@ -56,9 +93,13 @@ type tdRawConn struct {
 	strIdSuffix string        // suffix for every log string (e.g. to mark upload-only flows)
 }
 
-func makeTdRaw(handshakeType tdTagType, stationPubkey []byte) *tdRawConn {
+func makeTdRaw(handshakeType tdTagType, a *assets) *tdRawConn {
+	stationPubkey := a.GetPubkey()
 	tdRaw := &tdRawConn{tagType: handshakeType,
-		stationPubkey: stationPubkey,
+		assets:            a,
+		stationPubkey:     stationPubkey[:],
+		receiveBufferSize: defaultReceiveBufferSize,
+		maxDormant:        defaultMaxDormant,
 	}
 	tdRaw.closed = make(chan struct{})
 	return tdRaw
@@ -74,33 +115,55 @@ func (tdRaw *tdRawConn) RedialContext(ctx context.Context) error {
 }
 
 func (tdRaw *tdRawConn) dial(ctx context.Context, reconnect bool) error {
-	var maxConnectionAttempts int
+	var retryPolicy *RetryPolicy
 	var err error
 
 	dialStartTs := time.Now()
 	var expectedTransition pb.S2C_Transition
 	if reconnect {
-		maxConnectionAttempts = 5
+		retryPolicy = tdRaw.reconnectRetry
+		if retryPolicy == nil {
+			retryPolicy = DefaultReconnectRetryPolicy()
+		}
 		expectedTransition = pb.S2C_Transition_S2C_CONFIRM_RECONNECT
-		tdRaw.tlsConn.Close()
+		if tdRaw.tlsConn != nil {
+			// unless it's a clone, dialing the next connection in advance
+			tdRaw.tlsConn.Close()
+		}
 	} else {
-		maxConnectionAttempts = 20
+		retryPolicy = tdRaw.dialRetry
+		if retryPolicy == nil {
+			retryPolicy = DefaultDialRetryPolicy()
+		}
 		expectedTransition = pb.S2C_Transition_S2C_SESSION_INIT
 		if len(tdRaw.covert) > 0 {
 			expectedTransition = pb.S2C_Transition_S2C_SESSION_COVERT_INIT
 		}
 	}
 
-	for i := 0; i < maxConnectionAttempts; i++ {
+	if retryPolicy.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, retryPolicy.Budget)
+		defer cancel()
+	}
+
+	if !reconnect && tdRaw.canRace() {
+		return tdRaw.dialRace(ctx, expectedTransition, retryPolicy, dialStartTs)
+	}
+
+	for i := 0; i < retryPolicy.maxAttempts(); i++ {
 		if tdRaw.IsClosed() {
 			return errors.New("Closed")
 		}
+		if err := tdRaw.waitForBackoff(ctx); err != nil {
+			return err
+		}
 		// sleep to prevent overwhelming decoy servers
-		if waitTime := sleepBeforeConnect(i); waitTime != nil {
+		if delay := retryPolicy.delay(i); delay > 0 {
 			select {
-			case <-waitTime:
+			case <-time.After(delay):
 			case <-ctx.Done():
-				return context.Canceled
+				return ctx.Err()
 			case <-tdRaw.closed:
 				return errors.New("Closed")
 			}
@@ -110,11 +173,20 @@ func (tdRaw *tdRawConn) dial(ctx context.Context, reconnect bool) error {
 				return errors.New("decoySpec is pinned, but empty!")
 			}
 		} else {
+			// on reconnect decoy is kept, unless station reported it as overloaded,
+			// or, if rotation is enabled, previous attempt through it failed
+			var stationErr *StationError
 			if !reconnect {
-				tdRaw.decoySpec = Assets().GetDecoy()
-				if tdRaw.decoySpec.GetIpAddrStr() == "" {
-					return errors.New("tdConn.decoyAddr is empty!")
-				}
+				tdRaw.decoySpec = tdRaw.assets.GetDecoyWithSelector(tdRaw.decoySelector)
+			} else if (errors.As(err, &stationErr) &&
+				stationErr.Reason == pb.ErrorReasonS2C_DECOY_OVERLOAD) ||
+				(tdRaw.rotateDecoysOnReconnect && err != nil) {
+				Logger().Infoln(tdRaw.idStr() + " reconnecting via another decoy, instead of " +
+					decoyKey(&tdRaw.decoySpec))
+				tdRaw.decoySpec = tdRaw.pickAnotherDecoy()
+			}
+			if tdRaw.decoySpec.GetIpAddrStr() == "" {
+				return errors.New("tdConn.decoyAddr is empty!")
 			}
 		}
 
@@ -125,17 +197,236 @@ func (tdRaw *tdRawConn) dial(ctx context.Context, reconnect bool) error {
 			rand.Read(tdRaw.remoteConnId[:])
 		}
 
-		err = tdRaw.tryDialOnce(ctx, expectedTransition)
+		tdRaw.sessionStats.TlsToDecoy = nil
+		err = tdRaw.tryDialOnceWithFallback(ctx, expectedTransition)
+		tdRaw.reportDecoyOutcome(err)
 		if err == nil {
 			tdRaw.sessionStats.TotalTimeToConnect = durationToU32ptrMs(time.Since(dialStartTs))
+			tdRaw.hooks.stationConnected(tdRaw.initialMsg.GetStationId())
 			return nil
 		}
-		tdRaw.failedDecoys = append(tdRaw.failedDecoys,
-			tdRaw.decoySpec.GetHostname()+" "+tdRaw.decoySpec.GetIpAddrStr())
-		if tdRaw.sessionStats.FailedDecoysAmount == nil {
-			tdRaw.sessionStats.FailedDecoysAmount = new(uint32)
+		tdRaw.hooks.decoyFailed(decoyKey(&tdRaw.decoySpec), err)
+		tdRaw.recordFailedDecoy(tdRaw.decoySpec)
+	}
+	return err
+}
+
+// Picks decoy, other than the current one, unless there are no others.
+func (tdRaw *tdRawConn) pickAnotherDecoy() pb.TLSDecoySpec {
+	const maxPicks = 10
+	current := decoyKey(&tdRaw.decoySpec)
+	var decoy pb.TLSDecoySpec
+	for i := 0; i < maxPicks; i++ {
+		decoy = tdRaw.assets.GetDecoyWithSelector(tdRaw.decoySelector)
+		if decoyKey(&decoy) != current {
+			break
+		}
+	}
+	return decoy
+}
+
+func (tdRaw *tdRawConn) recordFailedDecoy(decoySpec pb.TLSDecoySpec) {
+	tdRaw.failedDecoys = append(tdRaw.failedDecoys, decoyKey(&decoySpec))
+	if tdRaw.sessionStats.FailedDecoysAmount == nil {
+		tdRaw.sessionStats.FailedDecoysAmount = new(uint32)
+	}
+	*tdRaw.sessionStats.FailedDecoysAmount += uint32(1)
+}
+
+// Racing is only possible when station responds to the initial request,
+// and there is a choice of decoys.
+func (tdRaw *tdRawConn) canRace() bool {
+	return tdRaw.raceDecoys > 1 && tdRaw.tagType == tagHttpGetIncomplete && !tdRaw.pinDecoySpec
+}
+
+// Dials up to tdRaw.raceDecoys decoys concurrently, starting them tdRaw.raceStagger apart
+// ("happy eyeballs"). First connection, picked up by the station, wins and is adopted by tdRaw.
+// Attempts are paced by retryPolicy, when it asks for longer delay than the stagger.
+// Losers are torn down in background.
+func (tdRaw *tdRawConn) dialRace(ctx context.Context, expectedTransition pb.S2C_Transition,
+	retryPolicy *RetryPolicy, dialStartTs time.Time) error {
+	maxConnectionAttempts := retryPolicy.maxAttempts()
+	type raceResult struct {
+		attempt *tdRawConn
+		err     error
+	}
+	// buffered, so that attempts never block after race is over
+	results := make(chan raceResult, maxConnectionAttempts)
+	raceCtx, cancelRace := context.WithCancel(ctx)
+	defer cancelRace()
+
+	inFlight := 0
+	abandonLosers := func() {
+		go func(inFlight int) {
+			for i := 0; i < inFlight; i++ {
+				if r := <-results; r.err == nil {
+					r.attempt.closePickedUp()
+				}
+			}
+		}(inFlight)
+	}
+
+	started := 0
+	// when to start next attempt, counting from the last start or failure
+	nextDelay := func() time.Duration {
+		if delay := retryPolicy.delay(started); delay > tdRaw.raceStagger {
+			return delay
+		}
+		return tdRaw.raceStagger
+	}
+	nextStart := time.NewTimer(retryPolicy.delay(0))
+	defer nextStart.Stop()
+	var err error
+	for {
+		var nextStartC <-chan time.Time
+		if started < maxConnectionAttempts && inFlight < tdRaw.raceDecoys {
+			nextStartC = nextStart.C
 		}
-		*tdRaw.sessionStats.FailedDecoysAmount += uint32(1)
+		select {
+		case <-nextStartC:
+			if backoff := tdRaw.assets.backoffRemaining(); backoff > 0 {
+				nextStart.Reset(backoff)
+				continue
+			}
+			attempt := tdRaw.cloneForDial()
+			attempt.decoySpec = tdRaw.assets.GetDecoyWithSelector(tdRaw.decoySelector)
+			if attempt.decoySpec.GetIpAddrStr() == "" {
+				abandonLosers()
+				return errors.New("tdConn.decoyAddr is empty!")
+			}
+			attempt.remoteConnId = make([]byte, 16)
+			rand.Read(attempt.remoteConnId[:])
+			go func() {
+				attemptErr := attempt.tryDialOnceWithFallback(raceCtx, expectedTransition)
+				attempt.reportDecoyOutcome(attemptErr)
+				results <- raceResult{attempt: attempt, err: attemptErr}
+			}()
+			started++
+			inFlight++
+			nextStart.Reset(nextDelay())
+		case r := <-results:
+			inFlight--
+			if r.err == nil {
+				tdRaw.adopt(r.attempt)
+				abandonLosers()
+				tdRaw.sessionStats.TotalTimeToConnect = durationToU32ptrMs(time.Since(dialStartTs))
+				tdRaw.hooks.stationConnected(tdRaw.initialMsg.GetStationId())
+				return nil
+			}
+			err = r.err
+			tdRaw.hooks.decoyFailed(decoyKey(&r.attempt.decoySpec), err)
+			tdRaw.recordFailedDecoy(r.attempt.decoySpec)
+			if started >= maxConnectionAttempts && inFlight == 0 {
+				return err
+			}
+			// replace failed attempt, as retry policy allows
+			if !nextStart.Stop() {
+				select {
+				case <-nextStart.C:
+				default:
+				}
+			}
+			nextStart.Reset(nextDelay())
+		case <-ctx.Done():
+			abandonLosers()
+			return ctx.Err()
+		case <-tdRaw.closed:
+			abandonLosers()
+			return errors.New("Closed")
+		}
+	}
+}
+
+// Blocks while station asks clients not to connect
+func (tdRaw *tdRawConn) waitForBackoff(ctx context.Context) error {
+	backoff := tdRaw.assets.backoffRemaining()
+	if backoff <= 0 {
+		return nil
+	}
+	Logger().Infof("%s station requested backoff, waiting for %s", tdRaw.idStr(), backoff)
+	select {
+	case <-time.After(backoff):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tdRaw.closed:
+		return errors.New("Closed")
+	}
+}
+
+// Returns a copy of tdRaw, that could be used to dial concurrently: it has the same options
+// and belongs to the same session, but has its own connection state.
+func (tdRaw *tdRawConn) cloneForDial() *tdRawConn {
+	clone := &tdRawConn{
+		covert:                  tdRaw.covert,
+		TcpDialer:               tdRaw.TcpDialer,
+		assets:                  tdRaw.assets,
+		decoySelector:           tdRaw.decoySelector,
+		decoySpec:               tdRaw.decoySpec,
+		clientHelloIDs:          tdRaw.clientHelloIDs,
+		allowTLS13:              tdRaw.allowTLS13,
+		dialRetry:               tdRaw.dialRetry,
+		reconnectRetry:          tdRaw.reconnectRetry,
+		hooks:                   tdRaw.hooks,
+		pinDecoySpec:            tdRaw.pinDecoySpec,
+		rotateDecoysOnReconnect: tdRaw.rotateDecoysOnReconnect,
+		stationPubkey:           tdRaw.stationPubkey,
+		tagType:                 tdRaw.tagType,
+		remoteConnId:            tdRaw.remoteConnId,
+		closed:                  tdRaw.closed, // clone is never closed on its own
+		sessionId:               tdRaw.sessionId,
+		strIdSuffix:             tdRaw.strIdSuffix,
+	}
+	clone.flowId.Set(tdRaw.flowId.Get())
+	return clone
+}
+
+// Takes over connection, established by a clone of tdRaw.
+func (tdRaw *tdRawConn) adopt(clone *tdRawConn) {
+	tdRaw.tcpConn = clone.tcpConn
+	tdRaw.tlsConn = clone.tlsConn
+	tdRaw.decoySpec = clone.decoySpec
+	tdRaw.remoteConnId = clone.remoteConnId
+	tdRaw.initialMsg = clone.initialMsg
+	tdRaw.establishedAt = clone.establishedAt
+	tdRaw.UploadLimit = clone.UploadLimit
+	tdRaw.sessionStats.TcpToDecoy = clone.sessionStats.TcpToDecoy
+	tdRaw.sessionStats.TlsToDecoy = clone.sessionStats.TlsToDecoy
+	tdRaw.sessionStats.RttToStation = clone.sessionStats.RttToStation
+}
+
+// reports outcome of the last attempt to dial current decoy to decoySelector
+func (tdRaw *tdRawConn) reportDecoyOutcome(err error) {
+	if tdRaw.decoySelector == nil {
+		return
+	}
+	outcome := DecoyOutcome{Err: err}
+	if tdRaw.sessionStats.TlsToDecoy != nil {
+		outcome.TlsToDecoy = time.Duration(*tdRaw.sessionStats.TlsToDecoy) * time.Millisecond
+	}
+	tdRaw.decoySelector.ReportOutcome(&tdRaw.decoySpec, outcome)
+}
+
+// Same as tryDialOnce, but if decoy picked cipher suite, that TapDance doesn't support,
+// tries the same decoy once more, parroting defaultClientHelloID.
+// Cipher rejection is only held against decoy, if fallback doesn't help.
+func (tdRaw *tdRawConn) tryDialOnceWithFallback(ctx context.Context,
+	expectedTransition pb.S2C_Transition) error {
+	err := tdRaw.tryDialOnce(ctx, expectedTransition)
+	var cipherErr *UnsupportedCipherError
+	if !errors.As(err, &cipherErr) {
+		return err
+	}
+	if tdRaw.pickClientHelloID() != defaultClientHelloID {
+		Logger().Infoln(tdRaw.idStr() + " retrying decoy with fallback ClientHello " +
+			defaultClientHelloID.Str())
+		tdRaw.fallbackClientHello = true
+		defer func() { tdRaw.fallbackClientHello = false }()
+		tdRaw.sessionStats.TlsToDecoy = nil
+		err = tdRaw.tryDialOnce(ctx, expectedTransition)
+	}
+	if errors.As(err, &cipherErr) {
+		tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventCipherRejected)
 	}
 	return err
 }
@@ -143,6 +434,7 @@ func (tdRaw *tdRawConn) dial(ctx context.Context, reconnect bool) error {
 func (tdRaw *tdRawConn) tryDialOnce(ctx context.Context, expectedTransition pb.S2C_Transition) (err error) {
 	Logger().Infoln(tdRaw.idStr() + " Attempting to connect to decoy " +
 		tdRaw.decoySpec.GetHostname() + " (" + tdRaw.decoySpec.GetIpAddrStr() + ")")
+	tdRaw.hooks.decoyAttempt(decoyKey(&tdRaw.decoySpec))
 
 	tlsToDecoyStartTs := time.Now()
 	err = tdRaw.establishTLStoDecoy(ctx)
@@ -151,6 +443,12 @@ func (tdRaw *tdRawConn) tryDialOnce(ctx context.Context, expectedTransition pb.S
 		Logger().Errorf(tdRaw.idStr() + " establishTLStoDecoy(" +
 			tdRaw.decoySpec.GetHostname() + "," + tdRaw.decoySpec.GetIpAddrStr() +
 			") failed with " + err.Error())
+		var certErr *DecoyCertificateError
+		if errors.As(err, &certErr) {
+			tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventCertificateRejected)
+		} else if ctx.Err() == nil {
+			tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventFailure)
+		}
 		return err
 	}
 	tdRaw.sessionStats.TlsToDecoy = durationToU32ptrMs(tlsToDecoyTotalTs)
@@ -162,22 +460,23 @@ func (tdRaw *tdRawConn) tryDialOnce(ctx context.Context, expectedTransition pb.S
 		tdRaw.tlsConn.Close()
 		return errors.New("Closed")
 	}
-
-	// Check if cipher is supported
-	cipherIsSupported := func(id uint16) bool {
-		for _, c := range tapDanceSupportedCiphers {
-			if c == id {
-				return true
-			}
-		}
-		return false
+	if ctx.Err() != nil {
+		// e.g. another decoy won the race. No request was sent yet, so there is nothing
+		// to complete: padding without request line would look like malformed request.
+		tdRaw.tlsConn.Close()
+		return ctx.Err()
 	}
-	if !cipherIsSupported(tdRaw.tlsConn.ConnectionState().CipherSuite) {
+
+	// Check if cipher is supported. TLS 1.3 changes layout of the tag, so unless station is
+	// known to support it, it's treated as unsupported cipher, and fallback is tried.
+	connState := tdRaw.tlsConn.ConnectionState()
+	if !cipherIsSupported(connState.CipherSuite) ||
+		connState.Version == tls.VersionTLS13 && !tdRaw.allowTLS13 {
 		Logger().Errorf("%s decoy %s offered unsupported cipher %d\n Client ciphers: %#v\n",
-			tdRaw.idStr(), tdRaw.decoySpec.GetHostname(),
-			tdRaw.tlsConn.ConnectionState().CipherSuite,
+			tdRaw.idStr(), tdRaw.decoySpec.GetHostname(), connState.CipherSuite,
 			tdRaw.tlsConn.HandshakeState.Hello.CipherSuites)
-		err = errors.New("Unsupported cipher.")
+		err = &UnsupportedCipherError{Decoy: decoyKey(&tdRaw.decoySpec),
+			CipherSuite: connState.CipherSuite}
 		tdRaw.tlsConn.Close()
 		return err
 	}
@@ -200,6 +499,7 @@ func (tdRaw *tdRawConn) tryDialOnce(ctx context.Context, expectedTransition pb.S
 	if err != nil {
 		Logger().Errorf(tdRaw.idStr() +
 			" Could not send initial TD request, error: " + err.Error())
+		tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventFailure)
 		tdRaw.tlsConn.Close()
 		return
 	}
@@ -216,30 +516,37 @@ func (tdRaw *tdRawConn) tryDialOnce(ctx context.Context, expectedTransition pb.S
 			if errIsTimeout(err) {
 				Logger().Errorf("%s %s: %v", tdRaw.idStr(),
 					"TapDance station didn't pick up the request", err)
-
-				// lame fix for issue #38 with abrupt drop of not picked up flows
-				tdRaw.tlsConn.SetDeadline(time.Now().Add(
-					getRandomDuration(deadlineTCPtoDecoyMin,
-						deadlineTCPtoDecoyMax)))
-				tdRaw.tlsConn.Write([]byte(getRandPadding(456, 789, 5) + "\r\n" +
-					"Connection: close\r\n\r\n"))
-				go readAndClose(tdRaw.tlsConn,
-					getRandomDuration(deadlineTCPtoDecoyMin,
-						deadlineTCPtoDecoyMax))
+				tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventNotPickedUp)
+				tdRaw.closeNotPickedUp()
+				err = &NotPickedUpError{Decoy: decoyKey(&tdRaw.decoySpec), Err: err}
 			} else {
 				// any other error will be fatal
 				Logger().Errorf(tdRaw.idStr() +
 					" fatal error reading from TapDance station: " +
 					err.Error())
+				tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventFailure)
 				tdRaw.tlsConn.Close()
 				return
 			}
 			return
 		}
+		if backoff := tdRaw.initialMsg.GetTmpBackoff(); backoff > 0 {
+			tdRaw.assets.setBackoff(time.Duration(backoff) * time.Second)
+		}
+		if tdRaw.initialMsg.GetStateTransition() == pb.S2C_Transition_S2C_ERROR {
+			err = &StationError{Reason: tdRaw.initialMsg.GetErrReason()}
+			Logger().Infof("%s Failed to connect to TapDance Station [%s]: %s",
+				tdRaw.idStr(), tdRaw.initialMsg.GetStationId(), err.Error())
+			if err.(*StationError).Reason == pb.ErrorReasonS2C_DECOY_OVERLOAD {
+				// another decoy will be tried
+				tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventOverloaded)
+			}
+			tdRaw.tlsConn.Close()
+			return err
+		}
 		if tdRaw.initialMsg.GetStateTransition() != expectedTransition {
-			err = errors.New("Init error: state transition mismatch!" +
-				" Received: " + tdRaw.initialMsg.GetStateTransition().String() +
-				" Expected: " + expectedTransition.String())
+			err = &TransitionMismatchError{Received: tdRaw.initialMsg.GetStateTransition(),
+				Expected: expectedTransition}
 			Logger().Infof("%s Failed to connect to TapDance Station [%s]: %s",
 				tdRaw.idStr(), tdRaw.initialMsg.GetStationId(), err.Error())
 			// this exceptional error implies that station has lost state, thus is fatal
@@ -254,9 +561,41 @@ func (tdRaw *tdRawConn) tryDialOnce(ctx context.Context, expectedTransition pb.S
 
 	// TapDance should NOT have a timeout, timeouts have to be handled by client and server
 	tdRaw.tlsConn.SetDeadline(time.Time{}) // unsets timeout
+	tdRaw.assets.recordDecoyEvent(&tdRaw.decoySpec, decoyEventSuccess)
 	return nil
 }
 
+// Closes connection, that wasn't picked up by the station, so it looks like an ordinary
+// abandoned HTTP request: finishes the request and waits for the decoy to close connection.
+func (tdRaw *tdRawConn) closeNotPickedUp() {
+	// lame fix for issue #38 with abrupt drop of not picked up flows
+	tdRaw.tlsConn.SetDeadline(time.Now().Add(
+		getRandomDuration(deadlineTCPtoDecoyMin,
+			deadlineTCPtoDecoyMax)))
+	tdRaw.tlsConn.Write([]byte(getRandPadding(456, 789, 5) + "\r\n" +
+		"Connection: close\r\n\r\n"))
+	go readAndClose(tdRaw.tlsConn,
+		getRandomDuration(deadlineTCPtoDecoyMin,
+			deadlineTCPtoDecoyMax))
+}
+
+// Closes connection, that was picked up by the station, but is no longer needed
+// (e.g. lost the race): asks station to close the session and then, same as
+// closeNotPickedUp(), waits for the other side to close connection.
+func (tdRaw *tdRawConn) closePickedUp() {
+	tdRaw.tlsConn.SetDeadline(time.Now().Add(
+		getRandomDuration(deadlineTCPtoDecoyMin,
+			deadlineTCPtoDecoyMax)))
+	_, err := tdRaw.writeTransition(pb.C2S_Transition_C2S_SESSION_CLOSE)
+	if err != nil {
+		tdRaw.tlsConn.Close()
+		return
+	}
+	go readAndClose(tdRaw.tlsConn,
+		getRandomDuration(deadlineTCPtoDecoyMin,
+			deadlineTCPtoDecoyMax))
+}
+
 func (tdRaw *tdRawConn) establishTLStoDecoy(ctx context.Context) error {
 	deadline, deadlineAlreadySet := ctx.Deadline()
 	if !deadlineAlreadySet {
@@ -291,13 +630,34 @@ func (tdRaw *tdRawConn) establishTLStoDecoy(ctx context.Context) error {
 		Logger().Infoln(tdRaw.idStr() + ": SNI was nil. Setting it to" +
 			config.ServerName)
 	}
-	// parrot Chrome 62 ClientHello
-	tdRaw.tlsConn = tls.UClient(dialConn, &config, tls.HelloChrome_62)
+	// certificate is verified by hand, to tell MITM'd decoys apart from network failures
+	var certErr error
+	roots := tdRaw.assets.GetRoots()
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certErr = verifyDecoyCertificate(rawCerts, config.ServerName, roots,
+			tdRaw.decoySpec.GetSpkiPins())
+		return certErr
+	}
+	tdRaw.trafficSecrets = &trafficSecretsLog{}
+	config.KeyLogWriter = tdRaw.trafficSecrets
+	helloID := tdRaw.pickClientHelloID()
+	tdRaw.tlsConn = tls.UClient(dialConn, &config, helloID)
 	err = tdRaw.tlsConn.BuildHandshakeState()
 	if err != nil {
 		dialConn.Close()
 		return err
 	}
+	if !offersSupportedCipher(tdRaw.tlsConn.HandshakeState.Hello.CipherSuites) {
+		Logger().Warningf("%s ClientHello %s offers no ciphers, supported by TapDance. "+
+			"Falling back to %s", tdRaw.idStr(), helloID.Str(), defaultClientHelloID.Str())
+		tdRaw.tlsConn = tls.UClient(dialConn, &config, defaultClientHelloID)
+		err = tdRaw.tlsConn.BuildHandshakeState()
+		if err != nil {
+			dialConn.Close()
+			return err
+		}
+	}
 	err = tdRaw.tlsConn.MarshalClientHello()
 	if err != nil {
 		dialConn.Close()
@@ -307,6 +667,9 @@ func (tdRaw *tdRawConn) establishTLStoDecoy(ctx context.Context) error {
 	err = tdRaw.tlsConn.Handshake()
 	if err != nil {
 		dialConn.Close()
+		if certErr != nil {
+			return &DecoyCertificateError{Decoy: decoyKey(&tdRaw.decoySpec), Err: certErr}
+		}
 		return err
 	}
 	closeWriter, ok := dialConn.(closeWriterConn)
@@ -317,6 +680,70 @@ func (tdRaw *tdRawConn) establishTLStoDecoy(ctx context.Context) error {
 	return nil
 }
 
+// Picks ClientHello to parrot for current decoy. Same decoy always gets the same ClientHello,
+// while decoys are spread across all of tdRaw.clientHelloIDs, so fingerprints rotate.
+func (tdRaw *tdRawConn) pickClientHelloID() tls.ClientHelloID {
+	if tdRaw.fallbackClientHello || len(tdRaw.clientHelloIDs) == 0 {
+		return defaultClientHelloID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(decoyKey(&tdRaw.decoySpec)))
+	return tdRaw.clientHelloIDs[h.Sum32()%uint32(len(tdRaw.clientHelloIDs))]
+}
+
+func offersSupportedCipher(cipherSuites []uint16) bool {
+	for _, c := range cipherSuites {
+		if cipherIsSupported(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verifies certificate chain of the decoy against roots (system roots, if nil) and,
+// if decoy has SPKI pins, checks that at least one certificate in the chain matches.
+func verifyDecoyCertificate(rawCerts [][]byte, serverName string, roots *x509.CertPool,
+	spkiPins [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("decoy presented no certificates")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+
+	if len(spkiPins) == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range spkiPins {
+				if bytes.Equal(spkiHash[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("none of the certificates in the chain match SPKI pins")
+}
+
 func (tdRaw *tdRawConn) Close() error {
 	var err error
 	tdRaw.closeOnce.Do(func() {
@@ -341,8 +768,6 @@ func (tdRaw *tdRawConn) prepareTDRequest(handshakeType tdTagType) (string, error
 	// Generate tag for the initial TapDance request
 	buf := new(bytes.Buffer) // What we have to encrypt with the shared secret using AES
 
-	masterKey := tdRaw.tlsConn.HandshakeState.MasterSecret
-
 	// write flags
 	flags := default_flags
 	if tdRaw.tagType == tagHttpPostIncomplete {
@@ -352,23 +777,41 @@ func (tdRaw *tdRawConn) prepareTDRequest(handshakeType tdTagType) (string, error
 		return "", err
 	}
 	buf.Write([]byte{0}) // Unassigned byte
-	negotiatedCipher := tdRaw.tlsConn.HandshakeState.State12.Suite.Id
-	if tdRaw.tlsConn.HandshakeState.ServerHello.Vers == tls.VersionTLS13 {
-		negotiatedCipher = tdRaw.tlsConn.HandshakeState.State13.Suite.Id
-	}
+	// ServerHello.Vers is always TLS 1.2 in TLS 1.3, real version is in supported_versions
+	connState := tdRaw.tlsConn.ConnectionState()
+	negotiatedCipher := connState.CipherSuite
 	buf.Write([]byte{byte(negotiatedCipher >> 8),
 		byte(negotiatedCipher & 0xff)})
-	buf.Write(masterKey[:])
+	if connState.Version == tls.VersionTLS13 {
+		if !tdRaw.allowTLS13 {
+			return "", errors.New("station doesn't support TLS 1.3 tags")
+		}
+		// Station tells layouts apart by the cipher suite: TLS 1.3 suites are 0x13XX.
+		// Instead of the master secret, there are client and server application
+		// traffic secrets, which station needs to derive keys of both directions.
+		secrets := tdRaw.trafficSecrets
+		if secrets == nil || len(secrets.client) == 0 || len(secrets.server) == 0 {
+			return "", errors.New("TLS 1.3 traffic secrets were not captured")
+		}
+		if len(secrets.client) > tls13SecretSlotLen || len(secrets.server) > tls13SecretSlotLen {
+			return "", errors.New("TLS 1.3 traffic secrets don't fit in the tag")
+		}
+		secretSlots := make([]byte, 2*tls13SecretSlotLen)
+		copy(secretSlots, secrets.client)
+		copy(secretSlots[tls13SecretSlotLen:], secrets.server)
+		buf.Write(secretSlots)
+	} else {
+		buf.Write(tdRaw.tlsConn.HandshakeState.MasterSecret)
+		err := WriteTlsLog(tdRaw.tlsConn.HandshakeState.Hello.Random,
+			tdRaw.tlsConn.HandshakeState.MasterSecret)
+		if err != nil {
+			Logger().Warningf("Failed to write TLS secret log: %s", err)
+		}
+	}
 	buf.Write(tdRaw.tlsConn.HandshakeState.ServerHello.Random)
 	buf.Write(tdRaw.tlsConn.HandshakeState.Hello.Random)
 	buf.Write(tdRaw.remoteConnId[:]) // connection id for persistence
 
-	err := WriteTlsLog(tdRaw.tlsConn.HandshakeState.Hello.Random,
-		tdRaw.tlsConn.HandshakeState.MasterSecret)
-	if err != nil {
-		Logger().Warningf("Failed to write TLS secret log: %s", err)
-	}
-
 	// Generate and marshal protobuf
 	transition := pb.C2S_Transition_C2S_SESSION_INIT
 	var covert *string
@@ -376,7 +819,7 @@ func (tdRaw *tdRawConn) prepareTDRequest(handshakeType tdTagType) (string, error
 		transition = pb.C2S_Transition_C2S_SESSION_COVERT_INIT
 		covert = &tdRaw.covert
 	}
-	currGen := Assets().GetGeneration()
+	currGen := tdRaw.assets.GetGeneration()
 	initProto := &pb.ClientToStation{
 		CovertAddress:       covert,
 		StateTransition:     &transition,
@@ -429,6 +872,12 @@ Content-Disposition: form-data; name=\"td.zip\"
 		httpTag = strings.Replace(httpTag, "\n", "\r\n", -1)
 	}
 
+	// Tag has to be in the very first application data record: keystream is generated for
+	// current sequence number of the client. Post-handshake messages of TLS 1.3, such as
+	// NewSessionTicket, are sent by the server and only processed, once we read response,
+	// so neither client's keys nor sequence number change before the tag is written.
+	// Keystream works the same way for every AEAD (AES-GCM and ChaCha20-Poly1305):
+	// Seal over zeros yields keystream, followed by authentication tag, that we don't use.
 	keystreamOffset := len(httpTag)
 	keystreamSize := (len(tag)/3+1)*4 + keystreamOffset // we can't use first 2 bits of every byte
 	wholeKeystream, err := tdRaw.tlsConn.GetOutKeystream(keystreamSize)
@@ -510,7 +959,7 @@ func (tdRaw *tdRawConn) writeTransition(transition pb.C2S_Transition) (n int, er
 	const paddingSmoothness = 5
 	paddingDecrement := 0 // reduce potential padding size by this value
 
-	currGen := Assets().GetGeneration()
+	currGen := tdRaw.assets.GetGeneration()
 	msg := pb.ClientToStation{
 		DecoyListGeneration: &currGen,
 		StateTransition:     &transition,
@@ -551,6 +1000,21 @@ func (tdRaw *tdRawConn) writeTransition(transition pb.C2S_Transition) (n int, er
 	return
 }
 
+// Sends padded protobuf with explicit NO_CHANGE transition, which station answers in kind.
+// Unlike keepalives, padding messages of traffic shaping have no transition, and aren't answered.
+func (tdRaw *tdRawConn) writeKeepalive() (n int, err error) {
+	transition := pb.C2S_Transition_C2S_NO_CHANGE
+	msg := pb.ClientToStation{
+		StateTransition: &transition,
+		Padding:         []byte(getRandPadding(keepalivePaddingMin, keepalivePaddingMax, 5))}
+	msgBytes, err := proto.Marshal(&msg)
+	if err != nil {
+		return
+	}
+	Logger().Debugln(tdRaw.idStr() + " sending keepalive")
+	return tdRaw.tlsConn.Write(getMsgWithHeader(msgProtobuf, msgBytes))
+}
+
 func (tdRaw *tdRawConn) IsClosed() bool {
 	select {
 	case <-tdRaw.closed:
@@ -0,0 +1,681 @@
+package tapdance
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agl/ed25519/extra25519"
+	utls "github.com/refraction-networking/utls"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+	"golang.org/x/crypto/curve25519"
+)
+
+const testDecoyHostname = "decoy.tapdance.test"
+
+// testDecoy is a local TLS server, that pretends to be a decoy.
+// Every accepted connection is passed to handle after TLS handshake.
+type testDecoy struct {
+	listener net.Listener
+	roots    *x509.CertPool
+	caCert   *x509.Certificate
+	keyLog   *keyLog
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{} // accepted connections, that are still handled
+	stopped  bool
+	handlers sync.WaitGroup // accept loop and handlers of connections
+}
+
+// stop closes listener and accepted connections, and waits for their handlers to return,
+// so that nothing, started by the test, outlives it
+func (d *testDecoy) stop() {
+	d.listener.Close()
+	d.mu.Lock()
+	d.stopped = true
+	for conn := range d.conns {
+		conn.Close()
+	}
+	d.mu.Unlock()
+	d.handlers.Wait()
+}
+
+func makeTestCert(t testing.TB, template, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// keyLog collects TLS secrets of the decoy, which it writes in NSS key log format
+type keyLog struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *keyLog) Write(line []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, string(line))
+	return len(line), nil
+}
+
+// returns hex-encoded secret with given label
+func (l *keyLog) secret(label string) string {
+	l.Lock()
+	defer l.Unlock()
+	for _, line := range l.lines {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == label {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+// if cipherSuite is not 0, it is the only TLS 1.2 suite, that decoy accepts
+func startTestDecoy(t testing.TB, maxVersion uint16, handle func(net.Conn),
+	cipherSuite uint16) *testDecoy {
+	var cipherSuites []uint16
+	if cipherSuite != 0 {
+		cipherSuites = []uint16{cipherSuite}
+	}
+	caCert, caKey := makeTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TapDance Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	leafCert, leafKey := makeTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: testDecoyHostname},
+		DNSNames:     []string{testDecoyHostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+
+	var keyLog keyLog
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafCert.Raw, caCert.Raw},
+			PrivateKey:  leafKey,
+		}},
+		MaxVersion:   maxVersion,
+		CipherSuites: cipherSuites,
+		KeyLogWriter: &keyLog,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	decoy := &testDecoy{listener: listener, roots: roots, caCert: caCert, keyLog: &keyLog,
+		conns: make(map[net.Conn]struct{})}
+	decoy.handlers.Add(1)
+	go func() {
+		defer decoy.handlers.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			decoy.mu.Lock()
+			if decoy.stopped {
+				decoy.mu.Unlock()
+				conn.Close()
+				return
+			}
+			decoy.conns[conn] = struct{}{}
+			decoy.handlers.Add(1)
+			decoy.mu.Unlock()
+			go func() {
+				defer decoy.handlers.Done()
+				defer func() {
+					decoy.mu.Lock()
+					delete(decoy.conns, conn)
+					decoy.mu.Unlock()
+					conn.Close()
+				}()
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				handle(conn)
+			}()
+		}
+	}()
+	return decoy
+}
+
+// makes tdRawConn, that connects to the test decoy, no matter which decoy is picked
+func (d *testDecoy) makeTdRaw(a *assets) *tdRawConn {
+	tdRaw := makeTdRaw(tagHttpGetIncomplete, a)
+	tdRaw.decoySpec = *pb.InitTLSDecoySpec("127.0.0.1", testDecoyHostname)
+	tdRaw.remoteConnId = make([]byte, 16)
+	tdRaw.TcpDialer = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		dialer := net.Dialer{}
+		return dialer.DialContext(ctx, network, d.listener.Addr().String())
+	}
+	return tdRaw
+}
+
+func discardConn(conn net.Conn) {
+	io.Copy(ioutil.Discard, conn)
+}
+
+func TestRaw_DecoyCertificate(t *testing.T) {
+	decoy := startTestDecoy(t, tls.VersionTLS12, discardConn, 0)
+	defer decoy.stop()
+
+	dialTestDecoy := func(roots *x509.CertPool, spkiPins [][]byte) error {
+		tdRaw := decoy.makeTdRaw(makeAssetsFromConf(nil, roots))
+		tdRaw.decoySpec.SpkiPins = spkiPins
+		err := tdRaw.establishTLStoDecoy(context.Background())
+		if err == nil {
+			tdRaw.tlsConn.Close()
+		}
+		return err
+	}
+
+	if err := dialTestDecoy(decoy.roots, nil); err != nil {
+		t.Fatalf("Failed to connect to decoy with valid certificate: %v", err)
+	}
+
+	var certErr *DecoyCertificateError
+	if err := dialTestDecoy(x509.NewCertPool(), nil); !errors.As(err, &certErr) {
+		t.Fatalf("Expected DecoyCertificateError for untrusted decoy, got %v", err)
+	}
+
+	caPin := sha256.Sum256(decoy.caCert.RawSubjectPublicKeyInfo)
+	if err := dialTestDecoy(decoy.roots, [][]byte{caPin[:]}); err != nil {
+		t.Fatalf("Failed to connect to decoy with matching SPKI pin: %v", err)
+	}
+
+	wrongPin := sha256.Sum256([]byte("MITM"))
+	if err := dialTestDecoy(decoy.roots, [][]byte{wrongPin[:]}); !errors.As(err, &certErr) {
+		t.Fatalf("Expected DecoyCertificateError for mismatching SPKI pin, got %v", err)
+	}
+}
+
+func TestRaw_ClientHelloRotation(t *testing.T) {
+	tdRaw := makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(nil, nil))
+	tdRaw.clientHelloIDs = []utls.ClientHelloID{utls.HelloChrome_62, utls.HelloChrome_70,
+		utls.HelloFirefox_63}
+
+	picked := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		tdRaw.decoySpec = *pb.InitTLSDecoySpec("10.0.0."+strconv.Itoa(i), "decoy.test")
+		helloID := tdRaw.pickClientHelloID()
+		if tdRaw.pickClientHelloID() != helloID {
+			t.Fatal("Same decoy got different ClientHellos")
+		}
+		picked[helloID.Str()] = true
+	}
+	if len(picked) < 2 {
+		t.Fatalf("ClientHellos didn't rotate across decoys: %v", picked)
+	}
+}
+
+func TestRaw_ClientHelloFallback(t *testing.T) {
+	supportedCiphers := tapDanceSupportedCiphers
+	defer func() { tapDanceSupportedCiphers = supportedCiphers }()
+
+	// decoy negotiates TLS 1.3, which station isn't known to support
+	decoy := startTestDecoy(t, tls.VersionTLS13, discardConn, 0)
+	defer decoy.stop()
+
+	tdRaw := decoy.makeTdRaw(makeAssetsFromConf(nil, decoy.roots))
+	tdRaw.clientHelloIDs = []utls.ClientHelloID{utls.HelloChrome_70}
+	expectedTransition := pb.S2C_Transition_S2C_SESSION_INIT
+
+	var cipherErr *UnsupportedCipherError
+	if err := tdRaw.tryDialOnce(context.Background(), expectedTransition); !errors.As(err, &cipherErr) {
+		t.Fatalf("Expected UnsupportedCipherError, got %v", err)
+	}
+	// unless it is: then the tag is sent, but there is no station to pick it up
+	var notPickedUpErr *NotPickedUpError
+	tdRaw.allowTLS13 = true
+	err := tdRaw.tryDialOnce(context.Background(), expectedTransition)
+	if !errors.As(err, &notPickedUpErr) {
+		t.Fatalf("Expected NotPickedUpError with TLS 1.3 allowed, got %v", err)
+	}
+	tdRaw.allowTLS13 = false
+
+	// with fallback TLS 1.2 is used
+	err = tdRaw.tryDialOnceWithFallback(context.Background(), expectedTransition)
+	if !errors.As(err, &notPickedUpErr) {
+		t.Fatalf("Expected NotPickedUpError after fallback, got %v", err)
+	}
+	cipherRejections := func(tdRaw *tdRawConn) float64 {
+		tdRaw.assets.health.Lock()
+		defer tdRaw.assets.health.Unlock()
+		if r, ok := tdRaw.assets.health.records[decoyKey(&tdRaw.decoySpec)]; ok {
+			return r.CipherRejections
+		}
+		return 0
+	}
+	if cipherRejections(tdRaw) != 0 {
+		t.Fatal("Cipher rejection was recorded, though fallback failed for another reason")
+	}
+
+	// decoy, that picks unsupported cipher with fallback too, is held responsible
+	tapDanceSupportedCiphers = []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}
+	if err = tdRaw.tryDialOnceWithFallback(context.Background(), expectedTransition); !errors.As(err, &cipherErr) {
+		t.Fatalf("Expected UnsupportedCipherError after fallback, got %v", err)
+	}
+	if cipherRejections(tdRaw) == 0 {
+		t.Fatal("Cipher rejection was not recorded, though fallback was rejected too")
+	}
+	tapDanceSupportedCiphers = supportedCiphers
+
+	// decoy, that works with fallback, is not held responsible for the rejection
+	station := &testStation{}
+	station.testDecoy = startTestDecoy(t, tls.VersionTLS13, station.serve, 0)
+	defer station.stop()
+	tdRaw = station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.clientHelloIDs = []utls.ClientHelloID{utls.HelloChrome_70}
+	if err = tdRaw.tryDialOnceWithFallback(context.Background(), expectedTransition); err != nil {
+		t.Fatal(err)
+	}
+	defer tdRaw.Close()
+	if cipherRejections(tdRaw) != 0 {
+		t.Fatal("Cipher rejection was recorded, though fallback succeeded")
+	}
+}
+
+// recordingConn keeps all the bytes, written by the client, for the test to look at them
+// the way station does
+type recordingConn struct {
+	*net.TCPConn
+	sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.Lock()
+	c.written.Write(b)
+	c.Unlock()
+	return c.TCPConn.Write(b)
+}
+
+// returns ciphertext of the last application data record, written by the client
+func (c *recordingConn) lastAppDataRecord(t *testing.T) []byte {
+	c.Lock()
+	defer c.Unlock()
+	var lastRecord []byte
+	written := c.written.Bytes()
+	for len(written) >= 5 {
+		recordLen := 5 + int(binary.BigEndian.Uint16(written[3:5]))
+		if recordLen > len(written) {
+			break
+		}
+		if written[0] == 23 { // application_data
+			lastRecord = written[5:recordLen]
+		}
+		written = written[recordLen:]
+	}
+	if lastRecord == nil {
+		t.Fatal("Client wrote no application data records")
+	}
+	return lastRecord
+}
+
+// extracts and decrypts the tag from ciphertext of the record with the request, like station does
+func decryptTestTag(t *testing.T, ciphertext []byte, tagChars int,
+	stationPrivkey *[32]byte) []byte {
+	ciphertext = ciphertext[len(ciphertext)-tagChars:]
+	tag := make([]byte, 0, tagChars/4*3)
+	for i := 0; i+3 < len(ciphertext); i += 4 {
+		ca, cb, cc, cd := ciphertext[i], ciphertext[i+1], ciphertext[i+2], ciphertext[i+3]
+		tag = append(tag, ((ca&0x3f)<<2)|((cb&0x30)>>4),
+			((cb&0x0f)<<4)|((cc&0x3c)>>2),
+			((cc&0x03)<<6)|(cd&0x3f))
+	}
+
+	var representative, clientPubkey, sharedSecret [32]byte
+	copy(representative[:], tag[:32])
+	representative[31] &= 0x7f
+	extra25519.RepresentativeToPublicKey(&clientPubkey, &representative)
+	curve25519.ScalarMult(&sharedSecret, stationPrivkey, &clientPubkey)
+	keys := sha256.Sum256(sharedSecret[:])
+
+	block, err := aes.NewCipher(keys[:16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesGcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stegoPayload, err := aesGcm.Open(nil, keys[16:28], tag[32:], nil)
+	if err != nil {
+		t.Fatalf("Station failed to decrypt the tag: %v", err)
+	}
+	return stegoPayload
+}
+
+func TestRaw_TagLayout(t *testing.T) {
+	var stationPrivkey, stationPubkey [32]byte
+	rand.Read(stationPrivkey[:])
+	curve25519.ScalarBaseMult(&stationPubkey, &stationPrivkey)
+
+	testTag := func(t *testing.T, maxVersion uint16, cipherSuite uint16,
+		secretsLen int, explicitNonceLen int) {
+		requestChan := make(chan int)
+		requestReceived := make(chan struct{})
+		testDone := make(chan struct{})
+		decoy := startTestDecoy(t, maxVersion, func(conn net.Conn) {
+			select {
+			case requestLen := <-requestChan:
+				io.ReadFull(conn, make([]byte, requestLen))
+				close(requestReceived)
+			case <-testDone:
+			}
+		}, cipherSuite)
+		defer decoy.stop()
+		defer close(testDone)
+
+		tdRaw := decoy.makeTdRaw(makeAssetsFromConf(nil, decoy.roots))
+		tdRaw.stationPubkey = stationPubkey[:]
+		tdRaw.clientHelloIDs = []utls.ClientHelloID{utls.HelloChrome_70}
+		tdRaw.allowTLS13 = true
+		var recConn *recordingConn
+		tdRaw.TcpDialer = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			conn, err := dialer.DialContext(ctx, network, decoy.listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			recConn = &recordingConn{TCPConn: conn.(*net.TCPConn)}
+			return recConn, nil
+		}
+		if err := tdRaw.establishTLStoDecoy(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer tdRaw.tlsConn.Close()
+		if tdRaw.tlsConn.ConnectionState().Version != maxVersion {
+			t.Fatalf("Negotiated unexpected TLS version: %x", tdRaw.tlsConn.ConnectionState().Version)
+		}
+		tdRequest, err := tdRaw.prepareTDRequest(tagHttpGetIncomplete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tdRaw.tlsConn.Write([]byte(tdRequest)); err != nil {
+			t.Fatal(err)
+		}
+		requestChan <- len(tdRequest)
+		<-requestReceived
+
+		// flags | unassigned | cipher suite | secrets | server random | client random | conn id
+		tagLen := 32 + 4 + secretsLen + 32 + 32 + 16 + 16
+		ciphertext := recConn.lastAppDataRecord(t)[explicitNonceLen:]
+		ciphertext = ciphertext[:len(tdRequest)]
+		stegoPayload := decryptTestTag(t, ciphertext, tagLen/3*4, &stationPrivkey)
+
+		if binary.BigEndian.Uint16(stegoPayload[2:4]) != tdRaw.tlsConn.ConnectionState().CipherSuite {
+			t.Fatalf("Tag has wrong cipher suite: %x", stegoPayload[2:4])
+		}
+		secrets := stegoPayload[4 : 4+secretsLen]
+		if secretsLen == 48 {
+			if hex.EncodeToString(secrets) != decoy.keyLog.secret("CLIENT_RANDOM") {
+				t.Fatal("Tag has wrong master secret")
+			}
+			return
+		}
+		clientSecret := hex.EncodeToString(secrets[:tls13SecretSlotLen])
+		serverSecret := hex.EncodeToString(secrets[tls13SecretSlotLen:])
+		if !strings.HasPrefix(clientSecret, decoy.keyLog.secret("CLIENT_TRAFFIC_SECRET_0")) ||
+			!strings.HasPrefix(serverSecret, decoy.keyLog.secret("SERVER_TRAFFIC_SECRET_0")) {
+			t.Fatal("Tag has wrong TLS 1.3 traffic secrets")
+		}
+	}
+
+	t.Run("TLS12_AES_GCM", func(t *testing.T) {
+		testTag(t, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, 48, 8)
+	})
+	t.Run("TLS12_ChaCha20", func(t *testing.T) {
+		testTag(t, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305, 48, 0)
+	})
+	t.Run("TLS13", func(t *testing.T) {
+		// cipher suites are not configurable in TLS 1.3
+		testTag(t, tls.VersionTLS13, 0, 2*tls13SecretSlotLen, 0)
+	})
+}
+
+func TestRaw_RotateDecoyOnReconnect(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+	station.mu.Lock()
+	station.conns = 1 // station only expects reconnects
+	station.mu.Unlock()
+
+	deadDecoy := pb.InitTLSDecoySpec("192.0.2.1", "dead.tapdance.test")
+	liveDecoy := pb.InitTLSDecoySpec("127.0.0.1", testDecoyHostname)
+	a := makeAssetsFromConf(&pb.ClientConf{
+		DecoyList: &pb.DecoyList{TlsDecoys: []*pb.TLSDecoySpec{deadDecoy, liveDecoy}},
+	}, station.roots)
+
+	reconnectViaDeadDecoy := func(rotate bool) (*tdRawConn, error) {
+		tdRaw := station.makeTdRaw(a)
+		stationDialer := tdRaw.TcpDialer
+		tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == deadDecoy.GetIpAddrStr() {
+				return nil, errors.New("decoy is unreachable")
+			}
+			return stationDialer(ctx, network, addr)
+		}
+		tdRaw.decoySpec = *deadDecoy
+		tdRaw.remoteConnId = []byte("session to keep!")
+		tdRaw.reconnectRetry = &RetryPolicy{MaxAttempts: 3}
+		tdRaw.rotateDecoysOnReconnect = rotate
+		return tdRaw, tdRaw.RedialContext(context.Background())
+	}
+
+	if _, err := reconnectViaDeadDecoy(false); err == nil {
+		t.Fatal("Reconnected through unreachable decoy")
+	}
+
+	tdRaw, err := reconnectViaDeadDecoy(true)
+	if err != nil {
+		t.Fatalf("Failed to reconnect via another decoy: %v", err)
+	}
+	defer tdRaw.Close()
+	if tdRaw.decoySpec.GetHostname() != liveDecoy.GetHostname() {
+		t.Fatalf("Reconnected via unexpected decoy %s", decoyKey(&tdRaw.decoySpec))
+	}
+	if string(tdRaw.remoteConnId) != "session to keep!" {
+		t.Fatal("remoteConnId changed on reconnect")
+	}
+}
+
+// pickUpTogetherConn holds the first read of every connection, until all expected requests
+// arrived, so that stations pick them up at the same time
+type pickUpTogetherConn struct {
+	net.Conn
+	arrived  *sync.WaitGroup
+	released bool
+}
+
+func (c *pickUpTogetherConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.released {
+		c.released = true
+		c.arrived.Done()
+		c.arrived.Wait()
+	}
+	return n, err
+}
+
+func TestRaw_DialRace(t *testing.T) {
+	// raced decoys share hostname, and are told apart by IP address
+	decoys := []*pb.TLSDecoySpec{
+		pb.InitTLSDecoySpec("127.0.0.1", testDecoyHostname),
+		pb.InitTLSDecoySpec("127.0.0.2", testDecoyHostname),
+	}
+	makeRacingTdRaw := func(listeners map[string]*testDecoy) *tdRawConn {
+		roots := x509.NewCertPool()
+		for _, decoy := range listeners {
+			roots.AddCert(decoy.caCert)
+		}
+		tdRaw := makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(&pb.ClientConf{
+			DecoyList: &pb.DecoyList{TlsDecoys: decoys},
+		}, roots))
+		tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, listeners[addr].listener.Addr().String())
+		}
+		tdRaw.decoySelector = NewRoundRobinDecoySelector()
+		tdRaw.raceDecoys = 2
+		tdRaw.raceStagger = 10 * time.Millisecond
+		return tdRaw
+	}
+	waitForEvent := func(station *testStation, event string) bool {
+		for start := time.Now(); time.Since(start) < 10*time.Second; {
+			station.mu.Lock()
+			events := strings.Join(station.events, "\n")
+			station.mu.Unlock()
+			if strings.Contains(events, event) {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	t.Run("PickedUpLoser", func(t *testing.T) {
+		// both decoys get picked up, loser has to close its session at the station
+		var arrived sync.WaitGroup
+		arrived.Add(len(decoys))
+		stations := make(map[string]*testStation)
+		listeners := make(map[string]*testDecoy)
+		for _, decoy := range decoys {
+			station := &testStation{}
+			station.testDecoy = startTestDecoy(t, tls.VersionTLS12, func(conn net.Conn) {
+				station.serve(&pickUpTogetherConn{Conn: conn, arrived: &arrived})
+			}, 0)
+			defer station.stop()
+			stations[decoy.GetIpAddrStr()] = station
+			listeners[decoy.GetIpAddrStr()] = station.testDecoy
+		}
+		tdRaw := makeRacingTdRaw(listeners)
+		// client waits for the station for 2x time to connect to decoy, which has to cover
+		// the time, that stations hold requests
+		dial := tdRaw.TcpDialer
+		tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			time.Sleep(100 * time.Millisecond)
+			return dial(ctx, network, addr)
+		}
+		tdRaw.raceStagger = time.Millisecond
+		if err := tdRaw.DialContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer tdRaw.Close()
+
+		winner := tdRaw.decoySpec.GetIpAddrStr()
+		for addr, station := range stations {
+			if addr == winner {
+				continue
+			}
+			if !waitForEvent(station, "C2S_SESSION_CLOSE 0") {
+				t.Fatalf("Loser %s didn't close its session", addr)
+			}
+		}
+		stations[winner].mu.Lock()
+		defer stations[winner].mu.Unlock()
+		if strings.Contains(strings.Join(stations[winner].events, "\n"), "C2S_SESSION_CLOSE") {
+			t.Fatal("Winner closed its session")
+		}
+	})
+
+	t.Run("CancelledLoser", func(t *testing.T) {
+		// slow decoy completes TLS handshake after the race is over, and is closed without
+		// any request, since half of one would look malformed
+		station := startTestStation(t)
+		defer station.stop()
+		abandonedRequest := make(chan string, 1)
+		slowDecoy := startTestDecoy(t, tls.VersionTLS12, func(conn net.Conn) {
+			var request []byte
+			buf := make([]byte, 4096)
+			for !bytes.HasSuffix(request, []byte("\r\n\r\n")) {
+				n, err := conn.Read(buf)
+				if err != nil {
+					break
+				}
+				request = append(request, buf[:n]...)
+			}
+			abandonedRequest <- string(request)
+		}, 0)
+		defer slowDecoy.stop()
+
+		fastAddr, slowAddr := decoys[0].GetIpAddrStr(), decoys[1].GetIpAddrStr()
+		tdRaw := makeRacingTdRaw(map[string]*testDecoy{
+			fastAddr: station.testDecoy,
+			slowAddr: slowDecoy,
+		})
+		// fast decoy only wins, once both are raced
+		slowStarted := make(chan struct{})
+		tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == slowAddr {
+				close(slowStarted)
+				<-ctx.Done()
+				return net.Dial(network, slowDecoy.listener.Addr().String())
+			}
+			<-slowStarted
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, station.listener.Addr().String())
+		}
+		if err := tdRaw.DialContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer tdRaw.Close()
+		if tdRaw.decoySpec.GetIpAddrStr() != fastAddr {
+			t.Fatalf("Unexpected winner %s", decoyKey(&tdRaw.decoySpec))
+		}
+
+		select {
+		case request := <-abandonedRequest:
+			if request != "" {
+				t.Fatalf("Loser sent data before closing: %q", request)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Loser was never closed")
+		}
+	})
+}
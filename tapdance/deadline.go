@@ -0,0 +1,70 @@
+package tapdance
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is an application-level deadline: it closes a channel, when it is exceeded.
+// Unlike deadlines of the underlying TLS connection, that are used internally to schedule
+// reconnects, it is fully controlled by the user via SetDeadline and friends.
+type deadline struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	exceeded chan struct{} // closed, when deadline is exceeded
+}
+
+func makeDeadline() *deadline {
+	return &deadline{exceeded: make(chan struct{})}
+}
+
+// set sets deadline to t. Zero t means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.exceeded // wait for timer callback to finish and close the channel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.exceeded)
+	if t.IsZero() {
+		if closed {
+			d.exceeded = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.exceeded = make(chan struct{})
+		}
+		exceeded := d.exceeded
+		d.timer = time.AfterFunc(dur, func() {
+			close(exceeded)
+		})
+		return
+	}
+
+	// deadline is in the past
+	if !closed {
+		close(d.exceeded)
+	}
+}
+
+// wait returns a channel, that is closed, when deadline is exceeded.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.exceeded
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
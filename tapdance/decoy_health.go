@@ -0,0 +1,270 @@
+package tapdance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// outcomes of connection attempts that decoyHealth keeps track of
+type decoyEvent int8
+
+const (
+	decoyEventSuccess decoyEvent = iota
+	decoyEventFailure
+	decoyEventCipherRejected
+	decoyEventNotPickedUp
+	decoyEventOverloaded
+	decoyEventCertificateRejected
+)
+
+// how fast recorded events are forgotten
+const decoyHealthHalfLife = 6 * time.Hour
+
+// decoy gets blacklisted, once its (decayed) failures outweigh successes by this much
+const decoyBlacklistThreshold = 3.0
+
+// for how long blacklisted decoy is skipped
+const decoyBlacklistDuration = 30 * time.Minute
+
+// for how long decoy, reported by station as overloaded, is skipped
+const decoyOverloadBlacklistDuration = 10 * time.Minute
+
+// for how long changes stay unsaved, so that bursts of connection attempts are written at once
+const decoyHealthFlushDelay = 5 * time.Second
+
+// records, whose counters decayed below this, are forgotten
+const decoyHealthForgetThreshold = 0.01
+
+// decoyHealth keeps track of outcomes of connection attempts per decoy and temporarily
+// blacklists decoys that keep failing. Stored in assets directory to survive restarts.
+// Changes are saved in background, so that dials don't wait for disk, and flushPending has
+// to be called before exit, not to lose the last of them.
+type decoyHealth struct {
+	sync.Mutex
+	filename   string // if empty, health records are kept in memory only
+	records    map[string]*decoyHealthRecord
+	flushTimer *time.Timer // delayed save of changes, nil if everything is saved
+
+	saveMu sync.Mutex // orders writes of the file, which happen without holding the lock
+}
+
+type decoyHealthRecord struct {
+	Successes        float64   `json:"successes"`
+	Failures         float64   `json:"failures"`
+	CipherRejections float64   `json:"cipher_rejections"`
+	NotPickedUp      float64   `json:"not_picked_up"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	BlacklistedUntil time.Time `json:"blacklisted_until"`
+}
+
+func makeDecoyHealth() *decoyHealth {
+	return &decoyHealth{records: make(map[string]*decoyHealthRecord)}
+}
+
+// exponentially decays recorded events according to time passed since last update
+func (r *decoyHealthRecord) decay(now time.Time) {
+	elapsed := now.Sub(r.UpdatedAt)
+	if elapsed <= 0 {
+		return
+	}
+	multiplier := math.Pow(0.5, float64(elapsed)/float64(decoyHealthHalfLife))
+	r.Successes *= multiplier
+	r.Failures *= multiplier
+	r.CipherRejections *= multiplier
+	r.NotPickedUp *= multiplier
+	r.UpdatedAt = now
+}
+
+// whether record has nothing left to remember
+func (r *decoyHealthRecord) forgotten(now time.Time) bool {
+	return r.Successes < decoyHealthForgetThreshold &&
+		r.Failures < decoyHealthForgetThreshold &&
+		r.CipherRejections < decoyHealthForgetThreshold &&
+		r.NotPickedUp < decoyHealthForgetThreshold &&
+		!now.Before(r.BlacklistedUntil)
+}
+
+func (h *decoyHealth) scheduleFlush() {
+	if h.filename == "" || h.flushTimer != nil {
+		return
+	}
+	h.flushTimer = time.AfterFunc(decoyHealthFlushDelay, func() {
+		if err := h.flushPending(); err != nil {
+			Logger().Warningln("could not save decoy health: " + err.Error())
+		}
+	})
+}
+
+// flushPending saves unsaved changes right away, e.g. on shutdown, instead of waiting for
+// their delayed save. Once it returns, the file isn't written, until health changes again.
+func (h *decoyHealth) flushPending() error {
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+
+	h.Lock()
+	pending := h.flushTimer != nil
+	if pending {
+		h.flushTimer.Stop()
+	}
+	h.Unlock()
+	if !pending {
+		// already saved, e.g. by delayed save, that was waiting for saveMu
+		return nil
+	}
+	return h.save()
+}
+
+// flush drops forgotten records and saves the rest
+func (h *decoyHealth) flush() error {
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+	return h.save()
+}
+
+// same as flush, but saveMu has to be held
+func (h *decoyHealth) save() error {
+	h.Lock()
+	if h.flushTimer != nil {
+		h.flushTimer.Stop()
+		h.flushTimer = nil
+	}
+	now := time.Now()
+	for key, r := range h.records {
+		r.decay(now)
+		if r.forgotten(now) {
+			delete(h.records, key)
+		}
+	}
+	filename := h.filename
+	buf, err := json.Marshal(h.records)
+	h.Unlock()
+	if filename == "" || err != nil {
+		return err
+	}
+
+	tmpFilename := path.Join(path.Dir(filename),
+		"."+path.Base(filename)+"."+getRandString(5)+".tmp")
+	err = ioutil.WriteFile(tmpFilename, buf, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// retain forgets records of decoys, that are not in the list, e.g. after ClientConf update
+func (h *decoyHealth) retain(decoys []*pb.TLSDecoySpec) {
+	if len(decoys) == 0 {
+		// no decoys are configured yet, nothing to compare with
+		return
+	}
+	keep := make(map[string]bool, len(decoys))
+	for _, decoy := range decoys {
+		keep[decoyKey(decoy)] = true
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	for key := range h.records {
+		if !keep[key] {
+			delete(h.records, key)
+			h.scheduleFlush()
+		}
+	}
+}
+
+func (r *decoyHealthRecord) badness() float64 {
+	// cipher rejections are deterministic, so they are weighted heavier
+	return r.Failures + 2*r.CipherRejections + r.NotPickedUp - r.Successes
+}
+
+// load replaces health records with ones stored in the file.
+// Missing file is not an error: there is simply no history yet.
+func (h *decoyHealth) load(filename string) error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.filename = filename
+	h.records = make(map[string]*decoyHealthRecord)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, &h.records)
+}
+
+func (h *decoyHealth) record(decoy *pb.TLSDecoySpec, event decoyEvent) {
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	r, ok := h.records[decoyKey(decoy)]
+	if !ok {
+		r = &decoyHealthRecord{UpdatedAt: now}
+		h.records[decoyKey(decoy)] = r
+	}
+	r.decay(now)
+	switch event {
+	case decoyEventSuccess:
+		r.Successes++
+		r.BlacklistedUntil = time.Time{}
+	case decoyEventFailure:
+		r.Failures++
+	case decoyEventCipherRejected:
+		r.CipherRejections++
+	case decoyEventNotPickedUp:
+		r.NotPickedUp++
+	case decoyEventCertificateRejected:
+		// possibly intercepted: don't wait for more evidence
+		r.Failures++
+		r.BlacklistedUntil = now.Add(decoyBlacklistDuration)
+	case decoyEventOverloaded:
+		// not decoy's fault, so counters are left intact
+		if until := now.Add(decoyOverloadBlacklistDuration); until.After(r.BlacklistedUntil) {
+			r.BlacklistedUntil = until
+		}
+	}
+	if r.badness() >= decoyBlacklistThreshold && r.BlacklistedUntil.Before(now) {
+		Logger().Infof("decoy %s keeps failing, skipping it for %s",
+			decoyKey(decoy), decoyBlacklistDuration)
+		r.BlacklistedUntil = now.Add(decoyBlacklistDuration)
+	}
+	h.scheduleFlush()
+}
+
+func (h *decoyHealth) isBlacklisted(decoy *pb.TLSDecoySpec) bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.isBlacklistedLocked(decoy, time.Now())
+}
+
+func (h *decoyHealth) isBlacklistedLocked(decoy *pb.TLSDecoySpec, now time.Time) bool {
+	r, ok := h.records[decoyKey(decoy)]
+	return ok && now.Before(r.BlacklistedUntil)
+}
+
+// returns decoys that are not blacklisted, or all decoys, if every one of them is blacklisted
+func (h *decoyHealth) filter(decoys []*pb.TLSDecoySpec) []*pb.TLSDecoySpec {
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	healthy := make([]*pb.TLSDecoySpec, 0, len(decoys))
+	for _, decoy := range decoys {
+		if !h.isBlacklistedLocked(decoy, now) {
+			healthy = append(healthy, decoy)
+		}
+	}
+	if len(healthy) == 0 {
+		return decoys
+	}
+	return healthy
+}
@@ -0,0 +1,123 @@
+package tapdance
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+func TestDecoyHealth_Blacklist(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp/", "decoyhealth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "decoy_health.json")
+
+	h := makeDecoyHealth()
+	defer h.flushPending()
+	if err = h.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	badDecoy := testSelectorDecoys[0]
+	h.record(badDecoy, decoyEventFailure)
+	h.record(badDecoy, decoyEventNotPickedUp)
+	if h.isBlacklisted(badDecoy) {
+		t.Fatal("Decoy was blacklisted too early")
+	}
+	h.record(badDecoy, decoyEventCipherRejected)
+	if !h.isBlacklisted(badDecoy) {
+		t.Fatal("Repeatedly failing decoy was not blacklisted")
+	}
+	for _, decoy := range h.filter(testSelectorDecoys) {
+		if decoy == badDecoy {
+			t.Fatal("Blacklisted decoy was not filtered out")
+		}
+	}
+
+	// blacklist has to survive restart
+	if err = h.flush(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := makeDecoyHealth()
+	defer restarted.flushPending()
+	if err = restarted.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.isBlacklisted(badDecoy) {
+		t.Fatal("Blacklist was not persisted")
+	}
+	if len(restarted.filter([]*pb.TLSDecoySpec{badDecoy})) != 1 {
+		t.Fatal("All decoys are blacklisted, but none were returned")
+	}
+
+	restarted.record(badDecoy, decoyEventSuccess)
+	if restarted.isBlacklisted(badDecoy) {
+		t.Fatal("Decoy stayed blacklisted after successful connection")
+	}
+}
+
+func TestDecoyHealth_Decay(t *testing.T) {
+	r := decoyHealthRecord{Failures: 4, Successes: 2, UpdatedAt: time.Now().Add(-decoyHealthHalfLife)}
+	r.decay(time.Now())
+	if r.Failures < 1.9 || r.Failures > 2.1 || r.Successes < 0.9 || r.Successes > 1.1 {
+		t.Fatalf("Expected records to halve after half-life, got %+v", r)
+	}
+}
+
+func TestDecoyHealth_Forget(t *testing.T) {
+	h := makeDecoyHealth()
+	oldDecoy, removedDecoy := testSelectorDecoys[0], testSelectorDecoys[1]
+	decoy := testSelectorDecoys[2]
+	h.record(oldDecoy, decoyEventFailure)
+	h.record(removedDecoy, decoyEventFailure)
+	h.record(decoy, decoyEventFailure)
+	h.records[decoyKey(oldDecoy)].UpdatedAt = time.Now().Add(-10 * decoyHealthHalfLife)
+	if err := h.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.records[decoyKey(oldDecoy)]; ok {
+		t.Fatal("Record, that decayed to zero, was not dropped")
+	}
+
+	h.retain([]*pb.TLSDecoySpec{oldDecoy, decoy})
+	if _, ok := h.records[decoyKey(removedDecoy)]; ok {
+		t.Fatal("Record of decoy, that is no longer in ClientConf, was not dropped")
+	}
+	if _, ok := h.records[decoyKey(decoy)]; !ok {
+		t.Fatal("Record of current decoy was dropped")
+	}
+}
+
+func TestDecoyHealth_FlushPending(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp/", "decoyhealth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "decoy_health.json")
+
+	h := makeDecoyHealth()
+	if err = h.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	h.record(testSelectorDecoys[0], decoyEventFailure)
+	if _, err = os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("Change was saved before flush delay: %v", err)
+	}
+	if err = h.flushPending(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filename); err != nil {
+		t.Fatalf("Pending change wasn't saved: %v", err)
+	}
+	h.Lock()
+	defer h.Unlock()
+	if h.flushTimer != nil {
+		t.Fatal("Delayed save wasn't cancelled")
+	}
+}
@@ -0,0 +1,206 @@
+package tapdance
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// DecoySelector decides which decoy to try next and learns from outcomes of previous attempts.
+// Same DecoySelector is shared by all connections of a Dialer, thus implementations
+// have to be goroutine-safe.
+type DecoySelector interface {
+	// SelectDecoy returns index of the decoy to try next. Provided decoys are never empty.
+	SelectDecoy(decoys []*pb.TLSDecoySpec) int
+	// ReportOutcome is called after each attempt to connect to the station via decoy.
+	ReportOutcome(decoy *pb.TLSDecoySpec, outcome DecoyOutcome)
+}
+
+// DecoyOutcome describes result of a single attempt to connect to the station via decoy.
+type DecoyOutcome struct {
+	Err        error         // nil, if attempt was successful
+	TlsToDecoy time.Duration // time to establish TLS connection to decoy. Zero, if TLS failed
+}
+
+// returns unique identifier of a decoy, in the format, used to report failed decoys
+func decoyKey(decoy *pb.TLSDecoySpec) string {
+	return decoy.GetHostname() + " " + decoy.GetIpAddrStr()
+}
+
+// NewUniformDecoySelector returns DecoySelector that picks uniformly random decoy on every attempt.
+// This is the default strategy.
+func NewUniformDecoySelector() DecoySelector {
+	return uniformDecoySelector{}
+}
+
+type uniformDecoySelector struct{}
+
+func (uniformDecoySelector) SelectDecoy(decoys []*pb.TLSDecoySpec) int {
+	return getRandInt(0, len(decoys)-1)
+}
+
+func (uniformDecoySelector) ReportOutcome(*pb.TLSDecoySpec, DecoyOutcome) {}
+
+// NewWeightedDecoySelector returns DecoySelector that picks random decoy, weighted by the
+// history of successful and failed attempts: decoys that keep failing are picked less often,
+// but never excluded completely.
+func NewWeightedDecoySelector() DecoySelector {
+	return &weightedDecoySelector{history: make(map[string]*decoyHistory)}
+}
+
+type decoyHistory struct {
+	successes int
+	failures  int
+}
+
+type weightedDecoySelector struct {
+	sync.Mutex
+	history map[string]*decoyHistory
+}
+
+func (s *weightedDecoySelector) SelectDecoy(decoys []*pb.TLSDecoySpec) int {
+	s.Lock()
+	defer s.Unlock()
+
+	weights := make([]float64, len(decoys))
+	totalWeight := 0.0
+	for i, decoy := range decoys {
+		// Laplace smoothing: unknown decoys get weight 1/2
+		weights[i] = 1.0 / 2.0
+		if h, ok := s.history[decoyKey(decoy)]; ok {
+			weights[i] = float64(h.successes+1) / float64(h.successes+h.failures+2)
+		}
+		totalWeight += weights[i]
+	}
+
+	const resolution = 1 << 30
+	point := float64(getRandInt(0, resolution-1)) / resolution * totalWeight
+	for i, w := range weights {
+		if point < w {
+			return i
+		}
+		point -= w
+	}
+	return len(decoys) - 1
+}
+
+func (s *weightedDecoySelector) ReportOutcome(decoy *pb.TLSDecoySpec, outcome DecoyOutcome) {
+	s.Lock()
+	defer s.Unlock()
+
+	h, ok := s.history[decoyKey(decoy)]
+	if !ok {
+		h = &decoyHistory{}
+		s.history[decoyKey(decoy)] = h
+	}
+	if outcome.Err == nil {
+		h.successes++
+	} else {
+		h.failures++
+	}
+}
+
+// NewRoundRobinDecoySelector returns DecoySelector that picks random decoy without replacement:
+// every decoy is tried once before any decoy is tried again.
+func NewRoundRobinDecoySelector() DecoySelector {
+	return &roundRobinDecoySelector{used: make(map[string]bool)}
+}
+
+type roundRobinDecoySelector struct {
+	sync.Mutex
+	used map[string]bool
+}
+
+func (s *roundRobinDecoySelector) SelectDecoy(decoys []*pb.TLSDecoySpec) int {
+	s.Lock()
+	defer s.Unlock()
+
+	var unused []int
+	for i, decoy := range decoys {
+		if !s.used[decoyKey(decoy)] {
+			unused = append(unused, i)
+		}
+	}
+	if len(unused) == 0 {
+		// every decoy was used: start new round
+		s.used = make(map[string]bool)
+		for i := range decoys {
+			unused = append(unused, i)
+		}
+	}
+	chosen := unused[getRandInt(0, len(unused)-1)]
+	s.used[decoyKey(decoys[chosen])] = true
+	return chosen
+}
+
+func (s *roundRobinDecoySelector) ReportOutcome(*pb.TLSDecoySpec, DecoyOutcome) {}
+
+// NewLatencyDecoySelector returns DecoySelector that picks decoy with the lowest observed
+// latency of TLS handshake. Decoys that were never tried are explored first, failures
+// are penalized.
+func NewLatencyDecoySelector() DecoySelector {
+	return &latencyDecoySelector{latencies: make(map[string]*decoyLatency)}
+}
+
+type decoyLatency struct {
+	tlsToDecoy          time.Duration // exponentially weighted moving average
+	consecutiveFailures int
+}
+
+type latencyDecoySelector struct {
+	sync.Mutex
+	latencies map[string]*decoyLatency
+}
+
+func (s *latencyDecoySelector) SelectDecoy(decoys []*pb.TLSDecoySpec) int {
+	s.Lock()
+	defer s.Unlock()
+
+	var untried []int
+	best := -1
+	var bestScore time.Duration
+	for i, decoy := range decoys {
+		l, ok := s.latencies[decoyKey(decoy)]
+		if !ok {
+			untried = append(untried, i)
+			continue
+		}
+		// every consecutive failure doubles the score
+		score := l.tlsToDecoy << uint(minInt(l.consecutiveFailures, 16))
+		if best == -1 || score < bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	if len(untried) > 0 {
+		return untried[getRandInt(0, len(untried)-1)]
+	}
+	return best
+}
+
+func (s *latencyDecoySelector) ReportOutcome(decoy *pb.TLSDecoySpec, outcome DecoyOutcome) {
+	s.Lock()
+	defer s.Unlock()
+
+	// failed decoys that never completed TLS get the worst latency we are willing to wait
+	const failedLatency = deadlineTCPtoDecoyMax * time.Millisecond
+	const ewmaWeight = 0.3
+
+	l, ok := s.latencies[decoyKey(decoy)]
+	if !ok {
+		l = &decoyLatency{tlsToDecoy: outcome.TlsToDecoy}
+		if outcome.TlsToDecoy == 0 {
+			l.tlsToDecoy = failedLatency
+		}
+		s.latencies[decoyKey(decoy)] = l
+	} else if outcome.TlsToDecoy != 0 {
+		l.tlsToDecoy = time.Duration(ewmaWeight*float64(outcome.TlsToDecoy) +
+			(1-ewmaWeight)*float64(l.tlsToDecoy))
+	}
+	if outcome.Err == nil {
+		l.consecutiveFailures = 0
+	} else {
+		l.consecutiveFailures++
+	}
+}
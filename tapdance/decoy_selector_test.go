@@ -0,0 +1,78 @@
+package tapdance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+var testSelectorDecoys = []*pb.TLSDecoySpec{
+	pb.InitTLSDecoySpec("0.1.2.3", "whatever.cn"),
+	pb.InitTLSDecoySpec("255.254.253.252", "particular.ir"),
+	pb.InitTLSDecoySpec("11.22.33.44", "what.is.up"),
+	pb.InitTLSDecoySpec("8.255.255.8", "heh.meh"),
+}
+
+func TestDecoySelector_RoundRobin(t *testing.T) {
+	s := NewRoundRobinDecoySelector()
+	for round := 0; round < 3; round++ {
+		picked := make(map[int]bool)
+		for i := 0; i < len(testSelectorDecoys); i++ {
+			idx := s.SelectDecoy(testSelectorDecoys)
+			if picked[idx] {
+				t.Fatalf("Round %d: decoy %d was picked twice", round, idx)
+			}
+			picked[idx] = true
+		}
+	}
+}
+
+func TestDecoySelector_Weighted(t *testing.T) {
+	s := NewWeightedDecoySelector()
+	deadDecoy := testSelectorDecoys[0]
+	for i := 0; i < 50; i++ {
+		s.ReportOutcome(deadDecoy, DecoyOutcome{Err: errors.New("dead")})
+	}
+	for _, decoy := range testSelectorDecoys[1:] {
+		s.ReportOutcome(decoy, DecoyOutcome{TlsToDecoy: time.Second})
+	}
+
+	deadPicked := 0
+	const samples = 1000
+	for i := 0; i < samples; i++ {
+		if s.SelectDecoy(testSelectorDecoys) == 0 {
+			deadPicked++
+		}
+	}
+	// dead decoy weight is 1/52, others are 2/3 each
+	if deadPicked > samples/20 {
+		t.Fatalf("Failing decoy was picked %d out of %d times", deadPicked, samples)
+	}
+}
+
+func TestDecoySelector_Latency(t *testing.T) {
+	s := NewLatencyDecoySelector()
+	tried := make(map[int]bool)
+	for i := 0; i < len(testSelectorDecoys); i++ {
+		idx := s.SelectDecoy(testSelectorDecoys)
+		if tried[idx] {
+			t.Fatalf("Decoy %d was picked again, while there are untried decoys", idx)
+		}
+		tried[idx] = true
+		s.ReportOutcome(testSelectorDecoys[idx],
+			DecoyOutcome{TlsToDecoy: time.Duration(idx+1) * 100 * time.Millisecond})
+	}
+	if idx := s.SelectDecoy(testSelectorDecoys); idx != 0 {
+		t.Fatalf("Expected fastest decoy 0 to be picked, got %d", idx)
+	}
+
+	s.ReportOutcome(testSelectorDecoys[0], DecoyOutcome{Err: errors.New("timeout"),
+		TlsToDecoy: 100 * time.Millisecond})
+	s.ReportOutcome(testSelectorDecoys[0], DecoyOutcome{Err: errors.New("timeout"),
+		TlsToDecoy: 100 * time.Millisecond})
+	if idx := s.SelectDecoy(testSelectorDecoys); idx != 1 {
+		t.Fatalf("Expected decoy 1 to be picked after decoy 0 failed, got %d", idx)
+	}
+}
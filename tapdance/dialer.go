@@ -2,15 +2,137 @@ package tapdance
 
 import (
 	"context"
+	"crypto/x509"
 	"net"
+	"time"
+
+	"github.com/refraction-networking/utls"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
 )
 
 var sessionsTotal CounterUint64
 
 // Dialer contains options and implements advanced functions for establishing TapDance connection.
+//
+// When station asks to back off (StationToClient.tmp_backoff), new dials and reconnects wait it
+// out. Backoff is shared by all Dialers, that use the same assets, i.e. by default every Dialer
+// in the process, including tdproxy: backoff, received by one session, delays the others.
 type Dialer struct {
+	// SplitFlows makes connections use separate flows for download and upload.
 	SplitFlows bool
-	TcpDialer  func(context.Context, string, string) (net.Conn, error)
+	// WaitForYieldConfirmation makes split flows hold off upload, until station confirms
+	// pairing of the flows with S2C_CONFIRM_YIELD, so that data, written before station paired
+	// the flows, isn't lost. Dial fails, if station doesn't confirm within 20 seconds, so only
+	// enable it, if stations support S2C_CONFIRM_YIELD: older ones never confirm.
+	WaitForYieldConfirmation bool
+	TcpDialer                func(context.Context, string, string) (net.Conn, error)
+
+	// DecoySelector picks decoys to connect through and learns from outcomes of the attempts.
+	// Shared by all connections of this Dialer. If nil, uniformly random decoy is picked.
+	DecoySelector DecoySelector
+
+	// RaceDecoys is the number of decoys to dial concurrently during initial dial.
+	// Connection through the first decoy, picked up by the station, is used; others are
+	// abandoned. Values below 2 disable racing: decoys are tried one by one.
+	RaceDecoys int
+	// RaceStagger is the delay between starting concurrent dials. Default: 250ms.
+	RaceStagger time.Duration
+
+	// ClientHelloIDs are uTLS ClientHello fingerprints to parrot, e.g. tls.HelloChrome_70
+	// or tls.HelloRandomized. Each decoy is consistently contacted with one of them, so
+	// fingerprints rotate across decoys. Parrots, that offer no ciphers supported by
+	// TapDance, or end up negotiating unsupported cipher, fall back to tls.HelloChrome_62,
+	// which is also the default.
+	ClientHelloIDs []tls.ClientHelloID
+	// AllowTLS13 lets decoys negotiate TLS 1.3, in which case the tag carries TLS 1.3
+	// traffic secrets instead of the master secret (see README). Only enable it, if stations
+	// support this layout. Otherwise, decoys, that pick TLS 1.3, are retried with the
+	// fallback ClientHello, which only offers TLS 1.2.
+	AllowTLS13 bool
+
+	// OverlapReconnects makes reconnects of bidirectional flows make-before-break: connection
+	// for the next flow is dialed in advance, while the current one is still in use, and writes
+	// switch over to it at once, instead of stalling for the whole reconnect. Not used with
+	// SplitFlows.
+	OverlapReconnects bool
+
+	// RotateDecoysOnReconnect makes flows try another decoy, when reconnect through the current
+	// one fails, e.g. because decoy became unreachable. Session is preserved, as long as the new
+	// decoy is served by the same station. By default, all reconnect attempts go through the
+	// same decoy. Writer flow of SplitFlows stays pinned to its decoy.
+	RotateDecoysOnReconnect bool
+
+	// IdleTimeout enables lazy reconnects: if there were no reads or writes for IdleTimeout,
+	// when it's time to reconnect, flow closes its connection to decoy, keeping the session at
+	// the station, and only reconnects on the next Write, or after MaxDormant. This saves
+	// decoy load and battery, but data, sent by destination meanwhile, is delayed.
+	// Zero disables lazy reconnects. Not used with SplitFlows.
+	IdleTimeout time.Duration
+	// MaxDormant limits how long idle flow stays without connection to decoy. Station has no
+	// way to wake the client up, so data, sent by destination to a dormant flow, is received
+	// only after the next Write or MaxDormant, whichever comes first. Station may also drop
+	// sessions, that stay without connection for too long. Longer MaxDormant saves more decoy
+	// load and battery at the cost of this latency. Zero means 1 minute, negative means idle
+	// flow waits for Write indefinitely.
+	MaxDormant time.Duration
+
+	// KeepaliveInterval enables liveness probing: if nothing was received from station for
+	// KeepaliveInterval, flow sends padded C2S_NO_CHANGE keepalive, which station answers. If
+	// station doesn't answer within KeepaliveTimeout, flow is closed with ErrStationUnresponsive,
+	// so that caller may redial, instead of waiting for the decoy timeout. Stations, that don't
+	// support keepalives, ignore them, so liveness is enforced only after station answered
+	// a keepalive once; until then, keepalives are harmless. Zero disables keepalives.
+	// Read-only flows of SplitFlows don't send keepalives, and rely on their paired writer flow.
+	KeepaliveInterval time.Duration
+	// KeepaliveTimeout is how long to wait for station to answer keepalive.
+	// Zero means KeepaliveInterval.
+	KeepaliveTimeout time.Duration
+
+	// Shaping pads data, sent by flows, to fixed record sizes, adds cover traffic, and smooths
+	// bursts, so that sizes and timing of covert traffic don't leak through the TLS stream
+	// to the decoy. Nil disables shaping.
+	Shaping *ShapingProfile
+
+	// UploadRate and DownloadRate limit bandwidth of each connection in bytes per second.
+	// Zero means no limit.
+	UploadRate   int
+	DownloadRate int
+	// UploadLimiter and DownloadLimiter limit bandwidth of all connections of the Dialer
+	// in total. Nil means no limit. See also SetGlobalRateLimiters.
+	UploadLimiter   *RateLimiter
+	DownloadLimiter *RateLimiter
+
+	// ReceiveBufferSize limits how much data connection buffers, while application doesn't
+	// read it. Once buffer is full, connection stops reading from decoy, which slows down
+	// the sender. Zero means 1 MiB, negative means unbounded.
+	ReceiveBufferSize int
+
+	// WriteQueueSize enables asynchronous Writes: Write returns, once data is queued, instead
+	// of waiting until it is sent, and blocks only while WriteQueueSize bytes are queued
+	// already. Small writes are coalesced into larger messages. Error of sending queued data
+	// is returned by the next Write. Zero means synchronous Writes.
+	WriteQueueSize int
+
+	// DialRetry controls how persistently decoys are tried during initial dial.
+	// If nil, DefaultDialRetryPolicy() is used.
+	DialRetry *RetryPolicy
+	// ReconnectRetry controls how persistently decoys are tried, when flow reconnects.
+	// If nil, DefaultReconnectRetryPolicy() is used.
+	ReconnectRetry *RetryPolicy
+
+	// Hooks are called on lifecycle events of connections, such as decoy attempts and
+	// reconnects. Nil disables hooks.
+	Hooks *Hooks
+
+	assets *assets // if nil, global Assets() are used
+}
+
+// NewDialerWithConf returns Dialer, that uses its own copy of provided ClientConf and roots
+// instead of global Assets(). ClientConf updates, sent by the station, will be applied to
+// this Dialer only and won't be stored to disk.
+// Station public key is taken from conf.DefaultPubkey.
+func NewDialerWithConf(conf *pb.ClientConf, roots *x509.CertPool) *Dialer {
+	return &Dialer{assets: makeAssetsFromConf(conf, roots)}
 }
 
 // Dial connects to the address on the named network.
@@ -54,14 +176,15 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 	}
 
 	if !d.SplitFlows {
-		flow, err := makeTdFlow(flowBidirectional, nil, address)
+		tdRaw := d.makeTdRaw(tagHttpGetIncomplete)
+		tdRaw.sessionId = sessionsTotal.GetAndInc()
+		flow, err := makeTdFlow(flowBidirectional, tdRaw, address)
 		if err != nil {
 			return nil, err
 		}
-		flow.tdRaw.TcpDialer = d.TcpDialer
 		return flow, flow.DialContext(ctx)
 	}
-	return dialSplitFlow(ctx, d.TcpDialer, address)
+	return dialSplitFlow(ctx, d, address)
 }
 
 // DialProxy establishes direct connection to TapDance station proxy.
@@ -75,3 +198,50 @@ func (d *Dialer) DialProxy() (net.Conn, error) {
 func (d *Dialer) DialProxyContext(ctx context.Context) (net.Conn, error) {
 	return d.DialContext(ctx, "tcp", "")
 }
+
+// returns assets, that connections of this Dialer should use
+func (d *Dialer) getAssets() *assets {
+	if d.assets != nil {
+		return d.assets
+	}
+	return Assets()
+}
+
+// makeTdRaw prepares raw TapDance connection, configured with options of this Dialer
+func (d *Dialer) makeTdRaw(handshakeType tdTagType) *tdRawConn {
+	tdRaw := makeTdRaw(handshakeType, d.getAssets())
+	tdRaw.TcpDialer = d.TcpDialer
+	tdRaw.decoySelector = d.DecoySelector
+	tdRaw.raceDecoys = d.RaceDecoys
+	tdRaw.raceStagger = d.RaceStagger
+	tdRaw.clientHelloIDs = d.ClientHelloIDs
+	tdRaw.allowTLS13 = d.AllowTLS13
+	tdRaw.overlapReconnects = d.OverlapReconnects
+	tdRaw.rotateDecoysOnReconnect = d.RotateDecoysOnReconnect
+	tdRaw.idleTimeout = d.IdleTimeout
+	if d.MaxDormant > 0 {
+		tdRaw.maxDormant = d.MaxDormant
+	} else if d.MaxDormant < 0 {
+		tdRaw.maxDormant = 0
+	}
+	tdRaw.keepaliveInterval = d.KeepaliveInterval
+	tdRaw.keepaliveTimeout = d.KeepaliveTimeout
+	tdRaw.shaping = d.Shaping
+	tdRaw.uploadRate = d.UploadRate
+	tdRaw.downloadRate = d.DownloadRate
+	tdRaw.uploadLimiter = d.UploadLimiter
+	tdRaw.downloadLimiter = d.DownloadLimiter
+	if d.ReceiveBufferSize > 0 {
+		tdRaw.receiveBufferSize = d.ReceiveBufferSize
+	} else if d.ReceiveBufferSize < 0 {
+		tdRaw.receiveBufferSize = 0
+	}
+	tdRaw.writeQueueSize = d.WriteQueueSize
+	tdRaw.dialRetry = d.DialRetry
+	tdRaw.hooks = d.Hooks
+	tdRaw.reconnectRetry = d.ReconnectRetry
+	if tdRaw.raceStagger == 0 {
+		tdRaw.raceStagger = defaultRaceStagger
+	}
+	return tdRaw
+}
@@ -0,0 +1,111 @@
+package tapdance
+
+import (
+	"errors"
+	"strconv"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// Errors, returned by Dialer and connections, survive wrapping,
+// so callers may inspect them with errors.Is and errors.As.
+
+// ErrClosedByApplication is returned by operations on connection, that was closed with Close().
+var ErrClosedByApplication = errors.New("closed by application layer")
+
+// ErrWriteClosed is returned by Write, while Close is telling station to close the session.
+var ErrWriteClosed = errors.New("write side of connection is closed")
+
+// ErrStationUnresponsive is returned, when station didn't answer keepalive in time,
+// see Dialer.KeepaliveInterval.
+var ErrStationUnresponsive = errors.New("station didn't answer keepalive in time")
+
+// ErrMsgClose signals that station gracefully closed the session.
+var ErrMsgClose = errors.New("MSG_CLOSE")
+
+// NotPickedUpError is returned when decoy was reachable, but TapDance station
+// didn't respond to the tag in time.
+type NotPickedUpError struct {
+	Decoy string // hostname and IP address of the decoy
+	Err   error  // underlying read error
+}
+
+func (e *NotPickedUpError) Error() string {
+	return "TapDance station didn't pick up the request via " + e.Decoy + ": " + e.Err.Error()
+}
+
+func (e *NotPickedUpError) Unwrap() error { return e.Err }
+
+// UnsupportedCipherError is returned when decoy picked TLS cipher suite, that TapDance can't use.
+type UnsupportedCipherError struct {
+	Decoy       string // hostname and IP address of the decoy
+	CipherSuite uint16
+}
+
+func (e *UnsupportedCipherError) Error() string {
+	return "decoy " + e.Decoy + " picked unsupported cipher suite " +
+		strconv.Itoa(int(e.CipherSuite))
+}
+
+// DecoyCertificateError is returned when certificate of the decoy failed verification
+// against roots from assets, or didn't match SPKI pins of the decoy, e.g. because
+// connection to the decoy is intercepted. Tag is never sent to such decoy.
+type DecoyCertificateError struct {
+	Decoy string // hostname and IP address of the decoy
+	Err   error  // verification error
+}
+
+func (e *DecoyCertificateError) Error() string {
+	return "certificate of decoy " + e.Decoy + " failed verification: " + e.Err.Error()
+}
+
+func (e *DecoyCertificateError) Unwrap() error { return e.Err }
+
+// StationError is returned when station reports an error and closes the session.
+type StationError struct {
+	Reason pb.ErrorReasonS2C
+}
+
+func (e *StationError) Error() string {
+	return "message from station: " + e.Reason.String()
+}
+
+// TransitionMismatchError is returned when station replied with unexpected state transition,
+// e.g. because station has lost the state of the session.
+type TransitionMismatchError struct {
+	Received pb.S2C_Transition
+	Expected pb.S2C_Transition
+}
+
+func (e *TransitionMismatchError) Error() string {
+	return "state transition mismatch! Received: " + e.Received.String() +
+		" Expected: " + e.Expected.String()
+}
+
+// ReconnectError is returned when flow failed to reconnect to the station.
+type ReconnectError struct {
+	Err error // error of the last attempt to redial
+}
+
+func (e *ReconnectError) Error() string {
+	return "reconnect: failed to Redial: " + e.Err.Error()
+}
+
+func (e *ReconnectError) Unwrap() error { return e.Err }
+
+// UploadLimitError is returned when upload limit of the decoy is too low to send any data,
+// even over a freshly reconnected flow.
+type UploadLimitError struct {
+	Limit int // upload limit in bytes
+}
+
+func (e *UploadLimitError) Error() string {
+	return "upload limit of " + strconv.Itoa(e.Limit) + " bytes is too low to send data"
+}
+
+// timeoutError is returned by Read and Write, when deadline, set by user, is exceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
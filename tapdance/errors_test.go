@@ -0,0 +1,37 @@
+package tapdance
+
+import (
+	"errors"
+	"testing"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+func TestErrors_SurviveFlowClose(t *testing.T) {
+	a := makeAssetsFromConf(nil, nil)
+
+	flow, err := makeTdFlow(flowBidirectional, makeTdRaw(tagHttpGetIncomplete, a), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transition := pb.S2C_Transition_S2C_ERROR
+	reason := pb.ErrorReasonS2C_STATION_INTERNAL
+	flow.processProto(pb.StationToClient{StateTransition: &transition, ErrReason: &reason})
+
+	_, err = flow.Write([]byte("data"))
+	var stationErr *StationError
+	if !errors.As(err, &stationErr) {
+		t.Fatalf("Expected StationError, got %v", err)
+	}
+	if stationErr.Reason != reason {
+		t.Fatalf("Expected reason %s, got %s", reason, stationErr.Reason)
+	}
+
+	flow, err = makeTdFlow(flowBidirectional, makeTdRaw(tagHttpGetIncomplete, a), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.Close(); !errors.Is(err, ErrClosedByApplication) {
+		t.Fatalf("Expected ErrClosedByApplication, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package tapdance
+
+// Hooks let application observe lifecycle of connections, e.g. to show progress or collect
+// metrics, without parsing logs. Any callback may be nil.
+// Callbacks are called synchronously by goroutines of connections, and may be called
+// concurrently, e.g. while decoys are raced, so they have to be quick and safe for
+// concurrent use. Decoys are identified by hostname and IP address, separated by space.
+// Callbacks must not call Read or Write of the connection, since they may be called by
+// goroutines, that serve these calls, and would deadlock. Close may be called from any
+// callback, but, called during reconnect, it can't tell station to close the session, and
+// returns after a few seconds.
+type Hooks struct {
+	// OnDecoyAttempt is called before connecting to the decoy.
+	OnDecoyAttempt func(decoy string)
+	// OnDecoyFailed is called, when connecting to the station through the decoy failed.
+	OnDecoyFailed func(decoy string, err error)
+	// OnStationConnected is called, once station picked up connection, during initial dial
+	// and every reconnect. Station ID may be empty.
+	OnStationConnected func(stationID string)
+	// OnReconnectStart is called, when flow starts to reconnect.
+	OnReconnectStart func()
+	// OnReconnectDone is called, once reconnect is over, with nil error on success.
+	OnReconnectDone func(err error)
+	// OnClientConfUpdated is called, once ClientConf, sent by the station, is applied.
+	OnClientConfUpdated func(generation uint32)
+	// OnClosed is called once flow is closed, with the reason. With SplitFlows, each of the
+	// flows reports it.
+	OnClosed func(err error)
+}
+
+func (h *Hooks) decoyAttempt(decoy string) {
+	if h != nil && h.OnDecoyAttempt != nil {
+		h.OnDecoyAttempt(decoy)
+	}
+}
+
+func (h *Hooks) decoyFailed(decoy string, err error) {
+	if h != nil && h.OnDecoyFailed != nil {
+		h.OnDecoyFailed(decoy, err)
+	}
+}
+
+func (h *Hooks) stationConnected(stationID string) {
+	if h != nil && h.OnStationConnected != nil {
+		h.OnStationConnected(stationID)
+	}
+}
+
+func (h *Hooks) reconnectStart() {
+	if h != nil && h.OnReconnectStart != nil {
+		h.OnReconnectStart()
+	}
+}
+
+func (h *Hooks) reconnectDone(err error) {
+	if h != nil && h.OnReconnectDone != nil {
+		h.OnReconnectDone(err)
+	}
+}
+
+func (h *Hooks) clientConfUpdated(generation uint32) {
+	if h != nil && h.OnClientConfUpdated != nil {
+		h.OnClientConfUpdated(generation)
+	}
+}
+
+func (h *Hooks) closed(err error) {
+	if h != nil && h.OnClosed != nil {
+		h.OnClosed(err)
+	}
+}
@@ -0,0 +1,141 @@
+package tapdance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+func TestFlow_Hooks(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+
+	var mu sync.Mutex
+	var events []string
+	logEvent := func(format string, args ...interface{}) {
+		mu.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	closed := make(chan struct{})
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.dialRetry = &RetryPolicy{MaxAttempts: 2, FreeAttempts: 2}
+	tdRaw.hooks = &Hooks{
+		OnDecoyAttempt:      func(decoy string) { logEvent("attempt %s", decoy) },
+		OnDecoyFailed:       func(decoy string, err error) { logEvent("failed %s", decoy) },
+		OnStationConnected:  func(stationID string) { logEvent("connected %q", stationID) },
+		OnReconnectStart:    func() { logEvent("reconnecting") },
+		OnReconnectDone:     func(err error) { logEvent("reconnected %v", err) },
+		OnClientConfUpdated: func(generation uint32) { logEvent("conf %d", generation) },
+		OnClosed: func(err error) {
+			logEvent("closed %v", errors.Is(err, ErrClosedByApplication))
+			close(closed)
+		},
+	}
+	dial := tdRaw.TcpDialer
+	failFirst := true
+	tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if failFirst {
+			failFirst = false
+			return nil, errors.New("unreachable")
+		}
+		return dial(ctx, network, addr)
+	}
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// exceeds upload limit, so flow reconnects
+	sent := make([]byte, 20000)
+	go func() {
+		for i := 0; i < len(sent); i += 1000 {
+			if _, err := flow.Write(sent[i : i+1000]); err != nil {
+				return
+			}
+		}
+	}()
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = io.ReadFull(flow, make([]byte, len(sent))); err != nil {
+		t.Fatal(err)
+	}
+	err = flow.processProto(pb.StationToClient{ConfigInfo: &pb.ClientConf{
+		Generation: proto.Uint32(tdRaw.assets.GetGeneration() + 1),
+		DecoyList:  &pb.DecoyList{TlsDecoys: []*pb.TLSDecoySpec{&tdRaw.decoySpec}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generation := tdRaw.assets.GetGeneration()
+	flow.Close()
+	// flow is torn down in background, once station is told to close the session
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Flow wasn't closed")
+	}
+
+	decoy := decoyKey(&tdRaw.decoySpec)
+	expected := []string{
+		"attempt " + decoy,
+		"failed " + decoy,
+		"attempt " + decoy,
+		`connected ""`,
+		"reconnecting",
+		"attempt " + decoy,
+		`connected ""`,
+		"reconnected <nil>",
+		fmt.Sprintf("conf %d", generation),
+		"closed true",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected events:\n%s\ngot:\n%s",
+			strings.Join(expected, "\n"), strings.Join(events, "\n"))
+	}
+}
+
+func TestHooks_CloseFromCallback(t *testing.T) {
+	tdRaw := makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(nil, nil))
+	var flow *TapdanceFlowConn
+	calls := 0
+	tdRaw.hooks = &Hooks{OnClosed: func(err error) {
+		calls++
+		flow.Close()
+	}}
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		flow.closeWithErrorOnce(errors.New("closed by station"))
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close from OnClosed deadlocked")
+	}
+	flow.Close()
+	if calls != 1 {
+		t.Fatalf("Expected OnClosed to be called once, got %d", calls)
+	}
+}
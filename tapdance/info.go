@@ -0,0 +1,93 @@
+package tapdance
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo is a snapshot of state of TapDance connection, see TapdanceFlowConn.Info.
+type ConnInfo struct {
+	SessionID  uint64
+	FlowID     uint64 // ID of the flow within the session, changes on reconnect
+	FlowType   string
+	Reconnects uint64
+
+	DecoyHostname string
+	DecoyAddr     string // IP address and port
+	StationID     string // empty, if station didn't report it
+
+	BytesSent     int64 // data, written by application and sent to the station
+	BytesReceived int64 // data, received from the station
+
+	// timings of establishing current connection to decoy and station
+	TcpToDecoy         time.Duration
+	TlsToDecoy         time.Duration
+	RttToStation       time.Duration
+	TotalTimeToConnect time.Duration
+
+	// ReadFlow is the state of the read-only flow, if connection was dialed with SplitFlows.
+	// In that case, the rest describes the upload flow, except for BytesReceived.
+	ReadFlow *ConnInfo
+}
+
+// connInfo keeps state of the flow, that can be read concurrently. Fields of tdRaw, that
+// change on reconnect, are published by the goroutine, which reconnects, while counters are
+// updated atomically by the engines.
+type connInfo struct {
+	mu         sync.Mutex
+	published  ConnInfo
+	remoteAddr net.Addr
+
+	bytesSent     int64  // accessed atomically
+	bytesReceived int64  // accessed atomically
+	reconnects    uint64 // guarded by mu
+}
+
+// publishes state of flowConn.tdRaw, has to be called after every (re)connect
+func (flowConn *TapdanceFlowConn) publishInfo(reconnected bool) {
+	tdRaw := flowConn.tdRaw
+	msToDuration := func(ms uint32) time.Duration {
+		return time.Duration(ms) * time.Millisecond
+	}
+	info := ConnInfo{
+		SessionID:          tdRaw.sessionId,
+		FlowID:             tdRaw.flowId.Get(),
+		FlowType:           flowConn.flowType.Str(),
+		DecoyHostname:      tdRaw.decoySpec.GetHostname(),
+		DecoyAddr:          tdRaw.decoySpec.GetIpAddrStr(),
+		StationID:          tdRaw.initialMsg.GetStationId(),
+		TcpToDecoy:         msToDuration(tdRaw.sessionStats.GetTcpToDecoy()),
+		TlsToDecoy:         msToDuration(tdRaw.sessionStats.GetTlsToDecoy()),
+		RttToStation:       msToDuration(tdRaw.sessionStats.GetRttToStation()),
+		TotalTimeToConnect: msToDuration(tdRaw.sessionStats.GetTotalTimeToConnect()),
+	}
+	flowConn.info.mu.Lock()
+	if reconnected {
+		flowConn.info.reconnects++
+	}
+	info.Reconnects = flowConn.info.reconnects
+	flowConn.info.published = info
+	flowConn.info.remoteAddr = tdRaw.tlsConn.RemoteAddr()
+	flowConn.info.mu.Unlock()
+}
+
+// Info returns snapshot of state of the connection. Safe for concurrent use.
+func (flowConn *TapdanceFlowConn) Info() ConnInfo {
+	flowConn.info.mu.Lock()
+	info := flowConn.info.published
+	flowConn.info.mu.Unlock()
+	info.BytesSent = atomic.LoadInt64(&flowConn.info.bytesSent)
+	info.BytesReceived = atomic.LoadInt64(&flowConn.info.bytesReceived)
+	return info
+}
+
+// Info returns snapshot of state of the connection. Safe for concurrent use.
+func (tdConn *DualConn) Info() ConnInfo {
+	info := tdConn.writerConn.Info()
+	readInfo := tdConn.readerConn.Info()
+	info.BytesReceived = readInfo.BytesReceived
+	info.ReadFlow = &readInfo
+	return info
+}
@@ -0,0 +1,72 @@
+package tapdance
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestFlow_Info(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(16000)
+	tdRaw.sessionId = 42
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	// snapshots are taken, while flow reconnects
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for !isClosedChan(done) {
+			flow.Info()
+			flow.RemoteAddr()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	sent := make([]byte, 20000)
+	go func() {
+		for i := 0; i < len(sent); i += 1000 {
+			if _, err := flow.Write(sent[i : i+1000]); err != nil {
+				return
+			}
+		}
+	}()
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = io.ReadFull(flow, make([]byte, len(sent))); err != nil {
+		t.Fatal(err)
+	}
+
+	info := flow.Info()
+	if info.BytesSent != int64(len(sent)) || info.BytesReceived != int64(len(sent)) {
+		t.Fatalf("Expected %d bytes sent and received, got %d and %d",
+			len(sent), info.BytesSent, info.BytesReceived)
+	}
+	if info.Reconnects != 1 || info.FlowID != 1 {
+		t.Fatalf("Expected single reconnect, got %d reconnects, flow ID %d",
+			info.Reconnects, info.FlowID)
+	}
+	if info.SessionID != 42 || info.FlowType != "FlowBidirectional" ||
+		info.DecoyHostname != tdRaw.decoySpec.GetHostname() ||
+		info.DecoyAddr != tdRaw.decoySpec.GetIpAddrStr() || info.ReadFlow != nil {
+		t.Fatalf("Unexpected info: %+v", info)
+	}
+	// test decoy listens on another port
+	host, _, _ := net.SplitHostPort(flow.RemoteAddr().String())
+	if decoyHost, _, _ := net.SplitHostPort(info.DecoyAddr); host != decoyHost {
+		t.Fatalf("RemoteAddr %v doesn't match decoy %s", flow.RemoteAddr(), info.DecoyAddr)
+	}
+}
@@ -0,0 +1,146 @@
+package tapdance
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter caps bandwidth with a token bucket: up to burst bytes pass at once, and
+// the bucket is refilled at rate bytes per second. Single RateLimiter may be shared by
+// many connections to limit them in total.
+type RateLimiter struct {
+	bucket *tokenBucket
+}
+
+// NewRateLimiter returns RateLimiter, that allows rate bytes per second on average, and
+// bursts of up to burst bytes. Burst below 1 means rate, i.e. one second worth of traffic.
+func NewRateLimiter(rate, burst int) *RateLimiter {
+	if rate < 1 {
+		rate = 1
+	}
+	if burst < 1 {
+		burst = rate
+	}
+	return &RateLimiter{bucket: newTokenBucket(rate, burst)}
+}
+
+var globalRateLimiters struct {
+	sync.Mutex
+	upload   *RateLimiter
+	download *RateLimiter
+}
+
+// SetGlobalRateLimiters limits bandwidth of all TapDance connections, dialed afterwards,
+// in total, on top of limits of their Dialers. Nil removes the limit.
+func SetGlobalRateLimiters(upload, download *RateLimiter) {
+	globalRateLimiters.Lock()
+	globalRateLimiters.upload = upload
+	globalRateLimiters.download = download
+	globalRateLimiters.Unlock()
+}
+
+// rateLimiters apply to the same traffic at once, e.g. limits of the connection, its Dialer,
+// and global one
+type rateLimiters []*RateLimiter
+
+// per-connection limit, if rate is set, followed by non-nil shared limiters
+func makeRateLimiters(rate int, shared ...*RateLimiter) rateLimiters {
+	var limiters rateLimiters
+	if rate > 0 {
+		limiters = append(limiters, NewRateLimiter(rate, 0))
+	}
+	for _, limiter := range shared {
+		if limiter != nil {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
+
+// Largest amount of data, that may be charged at once, for it to be sent without exceeding
+// any of the limiters: the smallest burst. Zero, if there are no limiters.
+func (limiters rateLimiters) burst() int {
+	burst := 0
+	for _, limiter := range limiters {
+		if b := int(limiter.bucket.burst); burst == 0 || b < burst {
+			burst = b
+		}
+	}
+	return burst
+}
+
+// Returns n bytes, that were charged, but not sent, to all the limiters.
+func (limiters rateLimiters) refund(n int) {
+	for _, limiter := range limiters {
+		limiter.bucket.refund(n)
+	}
+}
+
+// Waits until n bytes may pass all the limiters. Returns false, if deadline or closed
+// channel was closed first, in which case tokens, taken from any of the limiters, are returned.
+func (limiters rateLimiters) wait(n int, deadline, closed <-chan struct{}) bool {
+	for i, limiter := range limiters {
+		if !limiter.bucket.take(n, deadline, closed) {
+			for _, taken := range limiters[:i] {
+				taken.bucket.refund(n)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// tokenBucket allows to spend up to burst tokens at once, refilled at rate per second.
+// Safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst),
+		last: time.Now()}
+}
+
+// Takes n tokens, waiting until they are available. Taking more than burst is allowed,
+// and is paid for by waiting longer. Returns false without taking tokens, if deadline or
+// closed channel was closed first.
+func (tb *tokenBucket) take(n int, deadline, closed <-chan struct{}) bool {
+	tb.mu.Lock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	tb.tokens -= float64(n)
+	wait := time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	tb.mu.Unlock()
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-deadline:
+	case <-closed:
+	}
+	tb.refund(n)
+	return false
+}
+
+// Returns n tokens, that were taken, but not spent.
+func (tb *tokenBucket) refund(n int) {
+	tb.mu.Lock()
+	tb.tokens += float64(n)
+	tb.mu.Unlock()
+}
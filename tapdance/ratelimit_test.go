@@ -0,0 +1,127 @@
+package tapdance
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestFlow_RateLimit(t *testing.T) {
+	// echoes 25000 bytes through a flow, limited by given options
+	echo := func(limit func(tdRaw *tdRawConn)) time.Duration {
+		station := startTestStation(t)
+		defer station.stop()
+		tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+		tdRaw.pinDecoySpec = true
+		tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+		tdRaw.decoySpec.Tcpwin = proto.Uint32(1 << 20)
+		limit(tdRaw)
+		flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = flow.DialContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer flow.Close()
+
+		start := time.Now()
+		sent := make([]byte, 25000)
+		go func() {
+			for i := 0; i < len(sent); i += 1000 {
+				if _, err := flow.Write(sent[i : i+1000]); err != nil {
+					return
+				}
+			}
+		}()
+		flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if _, err = io.ReadFull(flow, make([]byte, len(sent))); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+
+	// 20000 bytes over burst at 50000 B/s take 400ms
+	if elapsed := echo(func(tdRaw *tdRawConn) {
+		tdRaw.uploadLimiter = NewRateLimiter(50000, 5000)
+	}); elapsed < 350*time.Millisecond {
+		t.Fatalf("Upload limit was ignored: echo took %v", elapsed)
+	}
+	if elapsed := echo(func(tdRaw *tdRawConn) {
+		tdRaw.downloadLimiter = NewRateLimiter(50000, 5000)
+	}); elapsed < 350*time.Millisecond {
+		t.Fatalf("Download limit was ignored: echo took %v", elapsed)
+	}
+
+	SetGlobalRateLimiters(NewRateLimiter(50000, 5000), nil)
+	defer SetGlobalRateLimiters(nil, nil)
+	if elapsed := echo(func(tdRaw *tdRawConn) {}); elapsed < 350*time.Millisecond {
+		t.Fatalf("Global upload limit was ignored: echo took %v", elapsed)
+	}
+}
+
+// large Write is sent in chunks, that fit in burst, as tokens become available
+func TestFlow_RateLimitChunks(t *testing.T) {
+	station := startTestStation(t)
+	defer station.stop()
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(1 << 20)
+	tdRaw.uploadLimiter = NewRateLimiter(50000, 5000)
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+
+	start := time.Now()
+	go flow.Write(make([]byte, 25000))
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = io.ReadFull(flow, make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("First burst took %v: whole Write was charged up front", elapsed)
+	}
+	if _, err = io.ReadFull(flow, make([]byte, 20000)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Fatalf("Write took %v: burst was exceeded", elapsed)
+	}
+
+	// tokens of data, that wasn't sent before deadline, are returned
+	flow.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := flow.Write(make([]byte, 25000)); n >= 25000 || err == nil {
+		t.Fatalf("Expected Write to time out, got %d, %v", n, err)
+	}
+	bucket := tdRaw.uploadLimiter.bucket
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	if bucket.tokens < -bucket.burst {
+		t.Fatalf("Tokens of data, that wasn't sent, were lost: %v left", bucket.tokens)
+	}
+}
+
+func TestRateLimiters_Refund(t *testing.T) {
+	fast := NewRateLimiter(1000, 1000)
+	slow := NewRateLimiter(1, 1)
+	limiters := rateLimiters{fast, slow}
+
+	// slow limiter times out, so tokens, taken from fast one, are returned
+	deadline := make(chan struct{})
+	close(deadline)
+	if limiters.wait(1000, deadline, nil) {
+		t.Fatal("Wait passed slow limiter")
+	}
+	if !fast.bucket.take(1000, deadline, nil) {
+		t.Fatal("Tokens of fast limiter weren't refunded")
+	}
+}
@@ -0,0 +1,67 @@
+package tapdance
+
+import (
+	"time"
+)
+
+// RetryPolicy controls how persistently decoys are tried, when dialing or reconnecting.
+// First FreeAttempts attempts are made right away, subsequent ones are delayed by
+// BaseDelay, doubling after each attempt up to MaxDelay, and randomized by Jitter.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of decoys to try. Values below 1 mean 1.
+	MaxAttempts int
+	// FreeAttempts is the number of attempts, made without delay.
+	FreeAttempts int
+	// BaseDelay is the delay before the first delayed attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps exponential growth of the delay. Zero means BaseDelay: delay doesn't grow.
+	MaxDelay time.Duration
+	// Jitter randomizes each delay by up to Jitter share of it in either direction, e.g.
+	// 0.2 gives delays from 80% to 120%. Should be within [0, 1].
+	Jitter float64
+	// Budget is the overall time limit of dialing or reconnecting, including all attempts
+	// and delays. Zero means no limit, beyond the deadline of the context.
+	Budget time.Duration
+}
+
+// DefaultDialRetryPolicy returns RetryPolicy, used for initial dials by default:
+// up to 20 decoys, waiting for a second between attempts after the first 6.
+func DefaultDialRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 20, FreeAttempts: 6, BaseDelay: time.Second}
+}
+
+// DefaultReconnectRetryPolicy returns RetryPolicy, used for reconnects by default:
+// up to 5 attempts without delays.
+func DefaultReconnectRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 5, FreeAttempts: 5}
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// returns how long to wait before given attempt (counting from 0)
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	if attempt < p.FreeAttempts || p.BaseDelay <= 0 {
+		return 0
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay < p.BaseDelay {
+		maxDelay = p.BaseDelay
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if p.Jitter > 0 {
+		jitter := int(float64(delay/time.Millisecond) * p.Jitter)
+		delay += time.Duration(getRandInt(-jitter, jitter)) * time.Millisecond
+	}
+	return delay
+}
@@ -0,0 +1,97 @@
+package tapdance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	dialPolicy := DefaultDialRetryPolicy()
+	for attempt := 0; attempt < dialPolicy.MaxAttempts; attempt++ {
+		expected := time.Duration(0)
+		if attempt >= 6 {
+			expected = time.Second
+		}
+		if delay := dialPolicy.delay(attempt); delay != expected {
+			t.Fatalf("Default policy: attempt %d got delay %v, expected %v", attempt, delay, expected)
+		}
+	}
+
+	policy := RetryPolicy{FreeAttempts: 1, BaseDelay: 100 * time.Millisecond,
+		MaxDelay: time.Second}
+	expected := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond,
+		400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for attempt, expectedDelay := range expected {
+		if delay := policy.delay(attempt); delay != expectedDelay {
+			t.Fatalf("Attempt %d got delay %v, expected %v", attempt, delay, expectedDelay)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(1); delay < 50*time.Millisecond || delay > 150*time.Millisecond {
+			t.Fatalf("Jittered delay %v is out of range", delay)
+		}
+	}
+}
+
+func TestRetryPolicy_Budget(t *testing.T) {
+	tdRaw := makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(&pb.ClientConf{
+		DecoyList: &pb.DecoyList{TlsDecoys: testSelectorDecoys},
+	}, nil))
+	attempts := 0
+	tdRaw.TcpDialer = func(context.Context, string, string) (net.Conn, error) {
+		attempts++
+		return nil, errors.New("decoy is unreachable")
+	}
+	tdRaw.dialRetry = &RetryPolicy{MaxAttempts: 100, BaseDelay: 50 * time.Millisecond,
+		Budget: 300 * time.Millisecond}
+
+	start := time.Now()
+	err := tdRaw.DialContext(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected budget to be exceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second || attempts >= 100 {
+		t.Fatalf("Budget was ignored: %d attempts in %v", attempts, time.Since(start))
+	}
+
+	attempts = 0
+	tdRaw.dialRetry = &RetryPolicy{MaxAttempts: 3}
+	if err = tdRaw.DialContext(context.Background()); err == nil || attempts != 3 {
+		t.Fatalf("Expected 3 failed attempts, got %d: %v", attempts, err)
+	}
+}
+
+func TestRetryPolicy_Race(t *testing.T) {
+	tdRaw := makeTdRaw(tagHttpGetIncomplete, makeAssetsFromConf(&pb.ClientConf{
+		DecoyList: &pb.DecoyList{TlsDecoys: testSelectorDecoys},
+	}, nil))
+	var attempts int32
+	tdRaw.TcpDialer = func(context.Context, string, string) (net.Conn, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("decoy is unreachable")
+	}
+	tdRaw.raceDecoys = 2
+	tdRaw.raceStagger = 10 * time.Millisecond
+	tdRaw.dialRetry = &RetryPolicy{MaxAttempts: 4, FreeAttempts: 1, BaseDelay: 100 * time.Millisecond,
+		MaxDelay: time.Second}
+
+	// failed attempts are replaced after retry delays: 100ms, 200ms and 400ms
+	start := time.Now()
+	if err := tdRaw.DialContext(context.Background()); err == nil {
+		t.Fatal("Dial succeeded without decoys")
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Fatalf("Racing ignored retry delays: %d attempts in %v", atomic.LoadInt32(&attempts), elapsed)
+	}
+	if attempts != 4 {
+		t.Fatalf("Expected 4 attempts, got %d", attempts)
+	}
+}
@@ -0,0 +1,347 @@
+package tapdance
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session multiplexes many logical streams over a single TapDance connection, so that
+// opening a stream doesn't require new TLS handshake with a decoy and round trip to the
+// station. Streams are carried in raw data messages of the underlying connection, using
+// framing of smux (version 1), so the covert destination is expected to be a smux server.
+//
+// Framing: version(1) | command(1) | length(2, little endian) | stream id(4, little endian),
+// followed by length bytes of payload.
+type Session struct {
+	conn net.Conn // underlying TapDance connection
+
+	streamsMu    sync.Mutex
+	streams      map[uint32]*Stream
+	nextStreamID uint32
+
+	writeToken chan struct{} // held by whoever writes a frame to conn
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error // why session was closed, set before closed is closed
+}
+
+const (
+	sessionVersion = 1
+
+	sessionCmdSYN = 0 // opens stream
+	sessionCmdFIN = 1 // closes stream
+	sessionCmdPSH = 2 // data
+	sessionCmdNOP = 3 // no operation, may be used as keepalive
+
+	sessionHeaderSize   = 8
+	sessionMaxFrameSize = 32768
+)
+
+// sessionKeepaliveInterval is how often Session sends NOP frames. Standard smux servers
+// drop sessions, that were silent for 30 seconds, and smux clients ping every 10.
+var sessionKeepaliveInterval = 10 * time.Second
+
+// ErrSessionClosed is returned by operations on streams of the closed Session.
+var ErrSessionClosed = errors.New("session closed")
+
+// NewSession starts multiplexing streams over conn, which is usually returned by
+// Dialer.DialProxy(). Session takes ownership of conn and closes it on Close().
+func NewSession(conn net.Conn) *Session {
+	s := &Session{
+		conn:         conn,
+		streams:      make(map[uint32]*Stream),
+		nextStreamID: 1, // client-initiated streams are odd
+		writeToken:   make(chan struct{}, 1),
+		closed:       make(chan struct{}),
+	}
+	s.writeToken <- struct{}{}
+	go s.recvLoop()
+	go s.keepaliveLoop(sessionKeepaliveInterval)
+	return s
+}
+
+// DialSession establishes TapDance connection to the covert address and starts Session over it.
+// Empty address means station proxy, like in DialProxy. Either way, the destination has to
+// demultiplex streams.
+func (d *Dialer) DialSession(ctx context.Context, address string) (*Session, error) {
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewSession(conn), nil
+}
+
+// OpenStream opens new logical stream over the session.
+func (s *Session) OpenStream() (*Stream, error) {
+	s.streamsMu.Lock()
+	if s.IsClosed() {
+		s.streamsMu.Unlock()
+		return nil, s.err()
+	}
+	id := s.nextStreamID
+	s.nextStreamID += 2
+	stream := makeStream(s, id)
+	s.streams[id] = stream
+	s.streamsMu.Unlock()
+
+	if err := s.writeFrame(sessionCmdSYN, id, nil, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// NumStreams returns the number of currently open streams.
+func (s *Session) NumStreams() int {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed returns true, if session was closed, either by user or due to an error of
+// the underlying connection. New session has to be established in that case.
+func (s *Session) IsClosed() bool {
+	return isClosedChan(s.closed)
+}
+
+// Close closes the session, all of its streams and the underlying connection.
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = err
+		close(s.closed)
+		s.conn.Close()
+
+		s.streamsMu.Lock()
+		for _, stream := range s.streams {
+			stream.readBuf.Unblock()
+		}
+		s.streams = make(map[uint32]*Stream)
+		s.streamsMu.Unlock()
+		if err != ErrSessionClosed {
+			Logger().Infoln("session closed: " + err.Error())
+		}
+	})
+}
+
+// returns the reason, why session was closed
+func (s *Session) err() error {
+	<-s.closed
+	return s.closeErr
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.streamsMu.Lock()
+	delete(s.streams, id)
+	s.streamsMu.Unlock()
+}
+
+func (s *Session) getStream(id uint32) *Stream {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	return s.streams[id]
+}
+
+// writes single frame. Gives up waiting for other writers, once cancel is closed.
+func (s *Session) writeFrame(cmd byte, id uint32, payload []byte, cancel <-chan struct{}) error {
+	select {
+	case <-s.writeToken:
+	case <-s.closed:
+		return s.err()
+	case <-cancel:
+		return timeoutError{}
+	}
+	defer func() { s.writeToken <- struct{}{} }()
+
+	frame := make([]byte, sessionHeaderSize+len(payload))
+	frame[0] = sessionVersion
+	frame[1] = cmd
+	binary.LittleEndian.PutUint16(frame[2:4], uint16(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], id)
+	copy(frame[sessionHeaderSize:], payload)
+	if _, err := s.conn.Write(frame); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+// sends NOP frames, so that the remote side doesn't consider idle session dead
+func (s *Session) keepaliveLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.writeFrame(sessionCmdNOP, 0, nil, nil); err != nil {
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// reads frames and dispatches them to streams, until the underlying connection fails
+func (s *Session) recvLoop() {
+	header := make([]byte, sessionHeaderSize)
+	for {
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			if err == io.EOF {
+				// streams must not mistake it for graceful close
+				err = io.ErrUnexpectedEOF
+			}
+			s.closeWithError(err)
+			return
+		}
+		if header[0] != sessionVersion {
+			s.closeWithError(errors.New("unsupported session version: " +
+				strconv.Itoa(int(header[0]))))
+			return
+		}
+		length := binary.LittleEndian.Uint16(header[2:4])
+		id := binary.LittleEndian.Uint32(header[4:8])
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			s.closeWithError(err)
+			return
+		}
+
+		switch header[1] {
+		case sessionCmdPSH:
+			if stream := s.getStream(id); stream != nil {
+				stream.readBuf.Write(payload, nil)
+			}
+		case sessionCmdFIN:
+			if stream := s.getStream(id); stream != nil {
+				stream.readBuf.Unblock()
+			}
+		case sessionCmdSYN:
+			// streams, initiated by the remote side, are not accepted
+			Logger().Debugln("session: rejecting stream " + strconv.FormatUint(uint64(id), 10) +
+				", opened by remote side")
+			go s.writeFrame(sessionCmdFIN, id, nil, nil)
+		case sessionCmdNOP:
+		default:
+			s.closeWithError(errors.New("unknown session command: " + strconv.Itoa(int(header[1]))))
+			return
+		}
+	}
+}
+
+// Stream is a logical connection, multiplexed over Session. Implements net.Conn.
+type Stream struct {
+	id      uint32
+	session *Session
+
+	readBuf       *readBuffer
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func makeStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:            id,
+		session:       s,
+		readBuf:       makeReadBuffer(0), // bounded buffer would stall all streams
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Read reads data, sent by remote side over this stream. Returns io.EOF, once remote side
+// closed the stream, and all the data was read.
+func (stream *Stream) Read(b []byte) (int, error) {
+	n, err := stream.readBuf.Read(b, stream.readDeadline.wait())
+	if err == io.EOF && stream.session.IsClosed() && stream.session.err() != ErrSessionClosed {
+		err = stream.session.err()
+	}
+	return n, err
+}
+
+// Write sends b over the stream, splitting it into frames, if needed.
+func (stream *Stream) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	written := 0
+	for {
+		if isClosedChan(stream.closed) {
+			return written, ErrClosedByApplication
+		}
+		chunk := b[written:]
+		if len(chunk) > sessionMaxFrameSize {
+			chunk = chunk[:sessionMaxFrameSize]
+		}
+		err := stream.session.writeFrame(sessionCmdPSH, stream.id, chunk, stream.writeDeadline.wait())
+		if err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		if written == len(b) {
+			return written, nil
+		}
+	}
+}
+
+// Close closes the stream and notifies remote side. Session stays open.
+func (stream *Stream) Close() error {
+	var err error
+	stream.closeOnce.Do(func() {
+		close(stream.closed)
+		stream.readBuf.Unblock()
+		stream.session.removeStream(stream.id)
+		err = stream.session.writeFrame(sessionCmdFIN, stream.id, nil, nil)
+	})
+	return err
+}
+
+// ID returns id of the stream within the session.
+func (stream *Stream) ID() uint32 {
+	return stream.id
+}
+
+// LocalAddr returns local address of the underlying connection.
+func (stream *Stream) LocalAddr() net.Addr {
+	return stream.session.conn.LocalAddr()
+}
+
+// RemoteAddr returns remote address of the underlying connection.
+func (stream *Stream) RemoteAddr() net.Addr {
+	return stream.session.conn.RemoteAddr()
+}
+
+// SetDeadline sets read and write deadlines of the stream.
+func (stream *Stream) SetDeadline(t time.Time) error {
+	stream.readDeadline.set(t)
+	stream.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets deadline for pending and future Read calls.
+func (stream *Stream) SetReadDeadline(t time.Time) error {
+	stream.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets deadline for pending and future Write calls.
+// Frame, that is already being written, is not interrupted.
+func (stream *Stream) SetWriteDeadline(t time.Time) error {
+	stream.writeDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,105 @@
+package tapdance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serves as the remote side of the session: echoes data of every stream back,
+// and closes stream, when client closes it
+func runEchoSessionServer(conn net.Conn) {
+	header := make([]byte, sessionHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.LittleEndian.Uint16(header[2:4]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		switch header[1] {
+		case sessionCmdPSH, sessionCmdFIN:
+			conn.Write(append(header, payload...))
+		}
+	}
+}
+
+func TestSession_Streams(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	go runEchoSessionServer(serverConn)
+	session := NewSession(clientConn)
+	defer session.Close()
+
+	streams := make([]*Stream, 3)
+	for i := range streams {
+		var err error
+		if streams[i], err = session.OpenStream(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if streams[0].ID() == streams[1].ID() {
+		t.Fatal("Streams have the same ID")
+	}
+
+	// large message is split into frames and reassembled
+	bigMsg := bytes.Repeat([]byte("TapDance"), sessionMaxFrameSize/4)
+	for i, stream := range streams {
+		msg := append([]byte{byte(i)}, bigMsg...)
+		go stream.Write(msg)
+		received := make([]byte, len(msg))
+		stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(stream, received); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, msg) {
+			t.Fatalf("Stream %d received wrong data", i)
+		}
+	}
+
+	streams[0].Close()
+	if _, err := streams[0].Write([]byte("data")); err != ErrClosedByApplication {
+		t.Fatalf("Expected ErrClosedByApplication, got %v", err)
+	}
+	if session.NumStreams() != 2 {
+		t.Fatalf("Expected 2 open streams, got %d", session.NumStreams())
+	}
+
+	// failure of the underlying connection breaks all streams
+	serverConn.Close()
+	streams[1].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := streams[1].Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Fatalf("Expected session error, got %v", err)
+	}
+	if !session.IsClosed() {
+		t.Fatal("Session is not closed after connection failure")
+	}
+	if _, err := session.OpenStream(); err == nil {
+		t.Fatal("Opened stream over closed session")
+	}
+}
+
+func TestSession_Keepalive(t *testing.T) {
+	defer func(interval time.Duration) { sessionKeepaliveInterval = interval }(sessionKeepaliveInterval)
+	sessionKeepaliveInterval = 50 * time.Millisecond
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	session := NewSession(clientConn)
+	defer session.Close()
+
+	// idle session keeps sending NOP frames
+	header := make([]byte, sessionHeaderSize)
+	for i := 0; i < 3; i++ {
+		serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(serverConn, header); err != nil {
+			t.Fatal(err)
+		}
+		if header[1] != sessionCmdNOP {
+			t.Fatalf("Expected NOP frame, got command %d", header[1])
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package tapdance
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// ShapingProfile describes how flows disguise sizes and timing of the data they send,
+// which otherwise leak through the TLS stream to the decoy.
+type ShapingProfile struct {
+	// RecordSizes are sizes of TLS records' plaintext, that raw data is sent in. Each chunk of
+	// data, including its header, is padded up to the smallest size it fits in with protobuf
+	// padding message, sent in the same record. Chunks, that don't fit the largest size, are
+	// split. Empty RecordSizes disable padding.
+	RecordSizes []int
+	// CoverInterval enables cover traffic: if nothing was sent for CoverInterval, randomized
+	// by half of it in either direction, padding record of one of the RecordSizes is sent.
+	// Zero disables cover traffic.
+	CoverInterval time.Duration
+	// Rate enables pacing: records are sent back to back, as long as they fit in Burst
+	// bytes, subsequent ones are delayed to keep up to Rate bytes per second.
+	// Zero Rate disables pacing.
+	Rate  int
+	Burst int
+}
+
+// size of raw data header, as long as chunk fits in maxInt16
+const rawDataHeaderLen = 2
+
+// smallest padding message: protobuf header, field tag, length and a single byte of padding
+const minPaddingMsgLen = 2 + 2 + 1 + 1
+
+// shaper applies ShapingProfile to flow. Owned by writer engine.
+type shaper struct {
+	recordSizes   []int
+	coverInterval time.Duration
+	pacer         *tokenBucket
+
+	nextCover time.Time
+}
+
+func newShaper(profile *ShapingProfile) *shaper {
+	if profile == nil {
+		return nil
+	}
+	s := &shaper{coverInterval: profile.CoverInterval}
+	for _, size := range profile.RecordSizes {
+		if size > rawDataHeaderLen+minPaddingMsgLen && size <= int(maxInt16) {
+			s.recordSizes = append(s.recordSizes, size)
+		}
+	}
+	sort.Ints(s.recordSizes)
+	if s.coverInterval > 0 && len(s.recordSizes) == 0 {
+		Logger().Warningln("cover traffic requires RecordSizes, disabling it")
+		s.coverInterval = 0
+	}
+	if profile.Rate > 0 {
+		s.pacer = newTokenBucket(profile.Rate, profile.Burst)
+	}
+	s.sent()
+	return s
+}
+
+// Packs beginning of data into a record, that fits in room bytes. Returns the record and
+// how much of data it carries.
+func (s *shaper) frame(data []byte, room int) (record []byte, dataLen int) {
+	dataLen = len(data)
+	recordSize := 0
+	for _, size := range s.recordSizes {
+		if size > room {
+			break
+		}
+		recordSize = size
+		if gap := size - rawDataHeaderLen - dataLen; gap == 0 || gap >= minPaddingMsgLen {
+			break
+		}
+	}
+	if recordSize != 0 {
+		// if data doesn't fit even the largest record: fill it, and leave the rest for the next
+		dataLen = minInt(dataLen, recordSize-rawDataHeaderLen)
+		if gap := recordSize - rawDataHeaderLen - dataLen; gap != 0 && gap < minPaddingMsgLen {
+			dataLen = recordSize - rawDataHeaderLen - minPaddingMsgLen
+		}
+	}
+	record = getMsgWithHeader(msgRawData, data[:dataLen])
+	if recordSize > len(record) {
+		record = append(record, makePaddingMsgs(recordSize-len(record))...)
+	}
+	return
+}
+
+// Returns padding record of random size, if it's time to send cover traffic.
+func (s *shaper) coverRecord(room int) []byte {
+	if s.coverInterval <= 0 || time.Now().Before(s.nextCover) {
+		return nil
+	}
+	size := s.recordSizes[getRandInt(0, len(s.recordSizes)-1)]
+	if size > room {
+		return nil
+	}
+	return makePaddingMsgs(size)
+}
+
+// Waits until record of given size may be sent. Returns false, if deadline or closed
+// channel was closed first.
+func (s *shaper) pace(size int, deadline, closed <-chan struct{}) bool {
+	if s.pacer == nil {
+		return true
+	}
+	return s.pacer.take(size, deadline, closed)
+}
+
+// records that something was sent, postponing cover traffic
+func (s *shaper) sent() {
+	if s.coverInterval > 0 {
+		jitter := int(s.coverInterval/time.Millisecond) / 2
+		s.nextCover = time.Now().Add(s.coverInterval +
+			time.Duration(getRandInt(-jitter, jitter))*time.Millisecond)
+	}
+}
+
+// Returns protobuf messages, carrying nothing but padding, of given total size, headers
+// included. Station ignores them. Size has to be at least minPaddingMsgLen.
+func makePaddingMsgs(size int) []byte {
+	var msgs []byte
+	for size > 0 {
+		msg := makePaddingMsg(minInt(size, int(maxInt16)))
+		if msg == nil || size-len(msg) != 0 && size-len(msg) < minPaddingMsgLen {
+			// length of padding can't be encoded to get exactly that size: split
+			msg = makePaddingMsg(minPaddingMsgLen)
+		}
+		msgs = append(msgs, msg...)
+		size -= len(msg)
+	}
+	return msgs
+}
+
+// returns nil, if there is no padding message of exactly given size
+func makePaddingMsg(size int) []byte {
+	body := size - 2 // protobuf header
+	for varintLen := 1; varintLen <= 3; varintLen++ {
+		paddingLen := body - 2 - varintLen // field tag and length
+		if paddingLen < 1 || proto.SizeVarint(uint64(paddingLen)) != varintLen {
+			continue
+		}
+		msgBytes, err := proto.Marshal(&pb.ClientToStation{
+			Padding: bytes.Repeat([]byte("#"), paddingLen)})
+		if err != nil || len(msgBytes) != body {
+			return nil
+		}
+		return getMsgWithHeader(msgProtobuf, msgBytes)
+	}
+	return nil
+}
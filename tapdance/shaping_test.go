@@ -0,0 +1,168 @@
+package tapdance
+
+import (
+	"bytes"
+	"context"
+	ctls "crypto/tls"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/sergeyfrolov/gotapdance/protobuf"
+)
+
+// splits record into outer protocol messages, returns raw data and checks that
+// the rest is padding only
+func parseShapedRecord(t *testing.T, record []byte) []byte {
+	var data []byte
+	for len(record) > 0 {
+		typeLen := int(int16(binary.BigEndian.Uint16(record)))
+		record = record[2:]
+		if typeLen < 0 {
+			data = append(data, record[:-typeLen]...)
+			record = record[-typeLen:]
+			continue
+		}
+		var msg pb.ClientToStation
+		if err := proto.Unmarshal(record[:typeLen], &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.StateTransition != nil || len(msg.Padding) == 0 ||
+			proto.Size(&msg) != proto.Size(&pb.ClientToStation{Padding: msg.Padding}) {
+			t.Fatalf("Padding message carries more than padding: %v", msg.String())
+		}
+		record = record[typeLen:]
+	}
+	return data
+}
+
+func TestShaping_Frame(t *testing.T) {
+	recordSizes := []int{1400, 100, 600}
+	s := newShaper(&ShapingProfile{RecordSizes: recordSizes})
+	for dataLen := 1; dataLen < 3000; dataLen += rand.Intn(50) + 1 {
+		data := make([]byte, dataLen)
+		rand.Read(data)
+		var reassembled []byte
+		for sent := 0; sent < dataLen; {
+			record, n := s.frame(data[sent:], 1<<20)
+			if n <= 0 {
+				t.Fatalf("Record carries no data of %d bytes", dataLen-sent)
+			}
+			if len(record) != 100 && len(record) != 600 && len(record) != 1400 {
+				t.Fatalf("Record of %d bytes doesn't match any size", len(record))
+			}
+			if dataLen-sent+rawDataHeaderLen <= 1400-minPaddingMsgLen && n != dataLen-sent {
+				t.Fatalf("Data of %d bytes was split needlessly", dataLen-sent)
+			}
+			reassembled = append(reassembled, parseShapedRecord(t, record)...)
+			sent += n
+		}
+		if !bytes.Equal(data, reassembled) {
+			t.Fatalf("Data of %d bytes was corrupted", dataLen)
+		}
+	}
+
+	// near upload limit, data is sent as is
+	if record, n := s.frame(make([]byte, 50), 80); n != 50 || len(record) != 52 {
+		t.Fatalf("Expected unpadded record, got %d bytes carrying %d", len(record), n)
+	}
+	for size := minPaddingMsgLen; size < 20000; size++ {
+		if msgs := makePaddingMsgs(size); len(msgs) != size {
+			t.Fatalf("Requested %d bytes of padding, got %d", size, len(msgs))
+		}
+	}
+}
+
+func TestShaping_Pacing(t *testing.T) {
+	pacer := newTokenBucket(10000, 1000)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		pacer.take(1000, nil, nil)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("6000 bytes at 10000 B/s with burst of 1000 took %v", elapsed)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if pacer.take(10000, cancel, nil) {
+		t.Fatal("Cancelled take succeeded")
+	}
+}
+
+// checks sizes of the records, that flow sends through the decoy
+func TestFlow_Shaping(t *testing.T) {
+	station := &testStation{}
+	station.testDecoy = startTestDecoy(t, ctls.VersionTLS12, station.serve,
+		ctls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+	defer station.stop()
+	const recordOverhead = 8 + 16 // explicit nonce and tag of AES-GCM
+
+	tdRaw := station.makeTdRaw(makeAssetsFromConf(nil, station.roots))
+	tdRaw.pinDecoySpec = true
+	tdRaw.decoySpec.Timeout = proto.Uint32(60000)
+	tdRaw.decoySpec.Tcpwin = proto.Uint32(1 << 20)
+	tdRaw.shaping = &ShapingProfile{RecordSizes: []int{256, 1024},
+		CoverInterval: 20 * time.Millisecond}
+	var recConn *recordingConn
+	dial := tdRaw.TcpDialer
+	tdRaw.TcpDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		recConn = &recordingConn{TCPConn: conn.(*net.TCPConn)}
+		return recConn, nil
+	}
+	flow, err := makeTdFlow(flowBidirectional, tdRaw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flow.DialContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer flow.Close()
+	recConn.Lock()
+	handshakeLen := recConn.written.Len()
+	recConn.Unlock()
+
+	sent := make([]byte, 20000)
+	rand.Read(sent)
+	go func() {
+		for i := 0; i < len(sent); {
+			n := minInt(rand.Intn(3000)+1, len(sent)-i)
+			if _, err := flow.Write(sent[i : i+n]); err != nil {
+				return
+			}
+			i += n
+		}
+	}()
+	received := make([]byte, len(sent))
+	flow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = io.ReadFull(flow, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("Shaped data was corrupted")
+	}
+	time.Sleep(100 * time.Millisecond) // let cover traffic flow
+
+	recConn.Lock()
+	written := recConn.written.Bytes()
+	recordSizes := make(map[int]int)
+	for offset := 0; offset+5 <= len(written); {
+		recordLen := int(binary.BigEndian.Uint16(written[offset+3 : offset+5]))
+		if offset >= handshakeLen && written[offset] == 23 { // application_data
+			recordSizes[recordLen-recordOverhead]++
+		}
+		offset += 5 + recordLen
+	}
+	recConn.Unlock()
+	if len(recordSizes) != 2 || recordSizes[256] == 0 || recordSizes[1024] == 0 {
+		t.Fatalf("Expected records of 256 and 1024 bytes only, got %v", recordSizes)
+	}
+}
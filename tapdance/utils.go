@@ -160,32 +160,26 @@ func getMsgWithHeader(msgType msgType, msgBytes []byte) []byte {
 	if len(msgBytes) == 0 {
 		return nil
 	}
-	bufSend := new(bytes.Buffer)
-	var err error
+	msg := appendMsgHeader(make([]byte, 0, 6+len(msgBytes)), msgType, len(msgBytes))
+	return append(msg, msgBytes...)
+}
+
+// Appends outer protocol header of a message of given type and length to dst.
+// Header is 2 bytes long, or 6 bytes for protobufs over 32K.
+func appendMsgHeader(dst []byte, msgType msgType, msgLen int) []byte {
 	switch msgType {
 	case msgProtobuf:
-		if len(msgBytes) <= int(maxInt16) {
-			bufSend.Grow(2 + len(msgBytes)) // to avoid double allocation
-			err = binary.Write(bufSend, binary.BigEndian, int16(len(msgBytes)))
-
-		} else {
-			bufSend.Grow(2 + 4 + len(msgBytes)) // to avoid double allocation
-			bufSend.Write([]byte{0, 0})
-			err = binary.Write(bufSend, binary.BigEndian, int32(len(msgBytes)))
+		if msgLen <= int(maxInt16) {
+			return append(dst, byte(msgLen>>8), byte(msgLen))
 		}
+		return append(dst, 0, 0,
+			byte(msgLen>>24), byte(msgLen>>16), byte(msgLen>>8), byte(msgLen))
 	case msgRawData:
-		err = binary.Write(bufSend, binary.BigEndian, int16(-len(msgBytes)))
+		typeLen := uint16(-int16(msgLen))
+		return append(dst, byte(typeLen>>8), byte(typeLen))
 	default:
-		panic("getMsgWithHeader() called with msgType: " + strconv.Itoa(int(msgType)))
-	}
-	if err != nil {
-		// shouldn't ever happen
-		Logger().Errorln("getMsgWithHeader() failed with error: ", err)
-		Logger().Errorln("msgType ", msgType)
-		Logger().Errorln("msgBytes ", msgBytes)
+		panic("appendMsgHeader() called with msgType: " + strconv.Itoa(int(msgType)))
 	}
-	bufSend.Write(msgBytes)
-	return bufSend.Bytes()
 }
 
 func uint16toInt16(i uint16) int16 {
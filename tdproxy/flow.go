@@ -6,7 +6,6 @@ import (
 	"io"
 	"net"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -39,9 +38,13 @@ func makeTapDanceFlow(proxy *TapDanceProxy, id uint64, splitFlows bool) *tapDanc
 }
 
 func (TDstate *tapDanceFlow) redirect() error {
-	dialer := tapdance.Dialer{SplitFlows: TDstate.splitFlows}
 	var err error
-	TDstate.servConn, err = dialer.DialProxy()
+	if TDstate.proxy.multiplexing() {
+		TDstate.servConn, err = TDstate.proxy.openStream()
+	} else {
+		dialer := TDstate.proxy.makeDialer(TDstate.splitFlows)
+		TDstate.servConn, err = dialer.DialProxy()
+	}
 	if err != nil {
 		TDstate.userConn.Close()
 		return err
@@ -71,7 +74,7 @@ func (TDstate *tapDanceFlow) redirect() error {
 		Logger.Debugf("{tapDanceFlow} forwardFromClientToServer returns, bytes sent: " +
 			strconv.FormatUint(uint64(n), 10))
 		if _err == nil {
-			_err = errors.New("closed by application layer")
+			_err = tapdance.ErrClosedByApplication
 		}
 		errChan <- _err
 		return
@@ -81,23 +84,28 @@ func (TDstate *tapDanceFlow) redirect() error {
 	go forwardFromClientToServer()
 
 	if err = <-errChan; err != nil {
-		if err.Error() == "MSG_CLOSE" || err.Error() == "closed by application layer" {
+		if errors.Is(err, tapdance.ErrMsgClose) || errors.Is(err, tapdance.ErrClosedByApplication) {
 			Logger.Debugln("[Session " + strconv.FormatUint(uint64(TDstate.id), 10) +
 				" Redirect function returns gracefully: " + err.Error())
 			TDstate.proxy.closedGracefully.Inc()
 			err = nil
 		} else {
-			str_err := err.Error()
-
-			// statistics
-			if strings.Contains(str_err, "TapDance station didn't pick up the request") {
-				TDstate.proxy.notPickedUp.Inc()
-			} else if strings.Contains(str_err, ": i/o timeout") {
-				TDstate.proxy.timedOut.Inc()
-			} else {
-				TDstate.proxy.unexpectedError.Inc()
-			}
+			TDstate.countError(err)
 		}
 	}
 	return err
 }
+
+// statistics
+func (TDstate *tapDanceFlow) countError(err error) {
+	var notPickedUpErr *tapdance.NotPickedUpError
+	var netErr net.Error
+	if errors.As(err, &notPickedUpErr) {
+		TDstate.proxy.notPickedUp.Inc()
+	} else if errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, tapdance.ErrStationUnresponsive) {
+		TDstate.proxy.timedOut.Inc()
+	} else {
+		TDstate.proxy.unexpectedError.Inc()
+	}
+}
@@ -3,6 +3,7 @@
 package tdproxy
 
 import (
+	"context"
 	"github.com/sergeyfrolov/gotapdance/tapdance"
 	"net"
 	"strconv"
@@ -43,6 +44,27 @@ type TapDanceProxy struct {
 	statsTicker *time.Ticker
 
 	stop bool
+
+	// if multiplexing is enabled, user connections are streams of this session
+	mux struct {
+		sync.Mutex
+		enabled bool
+		covert  string
+		session *tapdance.Session
+		dialing *sessionDial // dial of the session in progress, if any
+		stopped bool         // set by Stop(), sessions dialed afterwards are closed
+	}
+
+	// liveness probing of TapDance connections, see tapdance.Dialer.KeepaliveInterval
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+
+	// bandwidth limits of all connections of this proxy in total, nil if unlimited
+	uploadLimiter   *tapdance.RateLimiter
+	downloadLimiter *tapdance.RateLimiter
+
+	// see tapdance.Dialer.WaitForYieldConfirmation
+	waitForYieldConfirmation bool
 }
 
 func NewTapDanceProxy(listenPort int) *TapDanceProxy {
@@ -59,6 +81,110 @@ func NewTapDanceProxy(listenPort int) *TapDanceProxy {
 	return proxy
 }
 
+// EnableMultiplexing makes all user connections share a single TapDance session to covert,
+// instead of establishing new TapDance connection for each of them. Covert has to
+// demultiplex streams (see tapdance.Session). Session is reestablished, once it fails.
+func (proxy *TapDanceProxy) EnableMultiplexing(covert string) {
+	proxy.mux.Lock()
+	proxy.mux.enabled = true
+	proxy.mux.covert = covert
+	proxy.mux.Unlock()
+}
+
+// EnableKeepalives makes TapDance connections probe station, when it's silent for interval,
+// so that dead connections are closed within timeout, and users may reconnect.
+// Should be called before ListenAndServe.
+func (proxy *TapDanceProxy) EnableKeepalives(interval, timeout time.Duration) {
+	proxy.keepaliveInterval = interval
+	proxy.keepaliveTimeout = timeout
+}
+
+// SetRateLimits caps bandwidth of all connections, accepted by this proxy, in total,
+// in bytes per second. Zero means no limit. Should be called before ListenAndServe.
+func (proxy *TapDanceProxy) SetRateLimits(uploadRate, downloadRate int) {
+	proxy.uploadLimiter = nil
+	if uploadRate > 0 {
+		proxy.uploadLimiter = tapdance.NewRateLimiter(uploadRate, 0)
+	}
+	proxy.downloadLimiter = nil
+	if downloadRate > 0 {
+		proxy.downloadLimiter = tapdance.NewRateLimiter(downloadRate, 0)
+	}
+}
+
+// EnableYieldConfirmation makes split flow connections wait for station to confirm pairing
+// of the flows, before uploading. Only for stations, that support S2C_CONFIRM_YIELD.
+// Should be called before ListenAndServe.
+func (proxy *TapDanceProxy) EnableYieldConfirmation() {
+	proxy.waitForYieldConfirmation = true
+}
+
+func (proxy *TapDanceProxy) makeDialer(splitFlows bool) tapdance.Dialer {
+	return tapdance.Dialer{
+		SplitFlows:               splitFlows,
+		WaitForYieldConfirmation: proxy.waitForYieldConfirmation,
+		KeepaliveInterval:        proxy.keepaliveInterval,
+		KeepaliveTimeout:         proxy.keepaliveTimeout,
+		UploadLimiter:            proxy.uploadLimiter,
+		DownloadLimiter:          proxy.downloadLimiter,
+	}
+}
+
+func (proxy *TapDanceProxy) multiplexing() bool {
+	proxy.mux.Lock()
+	defer proxy.mux.Unlock()
+	return proxy.mux.enabled
+}
+
+// sessionDial lets concurrent openStream() calls wait for the same dial of the session.
+type sessionDial struct {
+	done chan struct{}
+	err  error // set before done is closed
+}
+
+// opens new stream over shared session, dialing the session first, if needed.
+// Session is dialed without holding the lock, so that multiplexing() doesn't stall.
+func (proxy *TapDanceProxy) openStream() (net.Conn, error) {
+	proxy.mux.Lock()
+	for proxy.mux.session == nil || proxy.mux.session.IsClosed() {
+		if dial := proxy.mux.dialing; dial != nil {
+			proxy.mux.Unlock()
+			<-dial.done
+			if dial.err != nil {
+				return nil, dial.err
+			}
+			proxy.mux.Lock()
+			continue
+		}
+
+		dial := &sessionDial{done: make(chan struct{})}
+		proxy.mux.dialing = dial
+		covert := proxy.mux.covert
+		proxy.mux.Unlock()
+
+		dialer := proxy.makeDialer(false)
+		session, err := dialer.DialSession(context.Background(), covert)
+		proxy.mux.Lock()
+		proxy.mux.dialing = nil
+		if err == nil && proxy.mux.stopped {
+			session.Close()
+			err = tapdance.ErrSessionClosed
+		}
+		if err == nil {
+			proxy.mux.session = session
+		}
+		dial.err = err
+		close(dial.done)
+		if err != nil {
+			proxy.mux.Unlock()
+			return nil, err
+		}
+	}
+	session := proxy.mux.session
+	proxy.mux.Unlock()
+	return session.OpenStream()
+}
+
 func (proxy *TapDanceProxy) statsHelper() error {
 	proxy.statsTicker = time.NewTicker(time.Second * time.Duration(60))
 	for range proxy.statsTicker.C {
@@ -73,6 +199,9 @@ func (proxy *TapDanceProxy) ListenAndServe() error {
 
 	proxy.State = ProxyStateListening
 	proxy.stop = false
+	proxy.mux.Lock()
+	proxy.mux.stopped = false
+	proxy.mux.Unlock()
 	if proxy.listener, err = net.Listen("tcp", listenAddress); err != nil {
 		proxy.State = ProxyStateError
 		return err
@@ -105,7 +234,16 @@ func (proxy *TapDanceProxy) Stop() error {
 		tdState.servConn.Close()
 	}
 	proxy.connections.Unlock()
+	proxy.mux.Lock()
+	proxy.mux.stopped = true
+	if proxy.mux.session != nil {
+		proxy.mux.session.Close()
+	}
+	proxy.mux.Unlock()
 	proxy.statsTicker.Stop()
+	if err := tapdance.Assets().FlushDecoyHealth(); err != nil {
+		Logger.Warningf("Failed to save decoy health: %v\n", err)
+	}
 	return nil
 }
 